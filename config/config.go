@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"net/url"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -19,6 +20,36 @@ type ServerConfig struct {
 	Host    string          `yaml:"host"`
 	Address string          `yaml:"address"`
 	Tls     ServerTlsConfig `yaml:"tls"`
+	Admin   AdminConfig     `yaml:"admin"`
+
+	// Forwarding headers are only trusted from these addresses/CIDRs, eg. the load balancer
+	TrustedProxies []string `yaml:"trustedProxies"`
+
+	// The one header the trusted proxies set, any others could have been sent by the client
+	ClientIpHeader string `yaml:"clientIpHeader"`
+}
+
+const ClientIpXForwardedFor = "X-Forwarded-For"
+const ClientIpForwarded = "Forwarded"
+const ClientIpXRealIp = "X-Real-IP"
+
+func (c *ServerConfig) ParseClientIpHeader() string {
+	switch strings.ToLower(c.ClientIpHeader) {
+	case "", strings.ToLower(ClientIpXForwardedFor):
+		return ClientIpXForwardedFor
+	case strings.ToLower(ClientIpForwarded):
+		return ClientIpForwarded
+	case strings.ToLower(ClientIpXRealIp):
+		return ClientIpXRealIp
+	}
+
+	panic("Unknown client IP header: " + c.ClientIpHeader)
+}
+
+type AdminConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"tokenFile"`
 }
 
 type ServerTlsConfig struct {
@@ -35,25 +66,349 @@ type ProxyConfig struct {
 }
 
 type ProxyHostConfig struct {
-	Host   string `yaml:"host"`
-	Scheme string `yaml:"scheme"`
+	Host     string             `yaml:"host"`
+	Scheme   string             `yaml:"scheme"`
+	Tls      TlsClientConfig    `yaml:"tls"`
+	CacheTtl string             `yaml:"cacheTtl"`
+	Filters  []FilterRuleConfig `yaml:"filters"`
+
+	// Requests over the max body size are rejected, bodies over the capture size are streamed through
+	// without being logged or cached
+	MaxRequestBodySize string `yaml:"maxRequestBodySize"`
+	MaxCaptureSize     string `yaml:"maxCaptureSize"`
+	MaxDecodedSize     string `yaml:"maxDecodedSize"`
+
+	Compression CompressionConfig `yaml:"compression"`
+	Cors        CorsConfig        `yaml:"cors"`
+}
+
+// Origins are exact ("https://www.example.com"), "*" or wildcard subdomains ("https://*.example.com"), anything
+// more involved can be matched with the regular expressions in allowedOriginPatterns which must match the whole origin
+type CorsConfig struct {
+	AllowedOrigins        []string `yaml:"allowedOrigins"`
+	AllowedOriginPatterns []string `yaml:"allowedOriginPatterns"`
+	AllowCredentials      bool     `yaml:"allowCredentials"`
+	AllowedMethods        []string `yaml:"allowedMethods"`
+	AllowedHeaders        []string `yaml:"allowedHeaders"`
+	ExposedHeaders        []string `yaml:"exposedHeaders"`
+	MaxAge                string   `yaml:"maxAge"`
+}
+
+// Any origin is allowed when none are configured, as it was before CORS could be configured
+func (c *CorsConfig) ParseAllowedOrigins() []string {
+	if len(c.AllowedOrigins) == 0 && len(c.AllowedOriginPatterns) == 0 {
+		return []string{"*"}
+	}
+
+	return c.AllowedOrigins
+}
+
+func (c *CorsConfig) AllowsAnyOrigin() bool {
+	for _, origin := range c.ParseAllowedOrigins() {
+		if origin == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *CorsConfig) ParseAllowedMethods() []string {
+	if len(c.AllowedMethods) == 0 {
+		return []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"}
+	}
+
+	return c.AllowedMethods
+}
+
+func (c *CorsConfig) ParseAllowedHeaders() []string {
+	if len(c.AllowedHeaders) == 0 {
+		return []string{"Content-Type", "Content-Encoding", "Origin", "Accept", "Authorization", "Token", "X-App", "X-Index"}
+	}
+
+	return c.AllowedHeaders
+}
+
+// The request id and trace context are returned on every response so front-ends can report them
+func (c *CorsConfig) ParseExposedHeaders() []string {
+	if len(c.ExposedHeaders) == 0 {
+		return []string{"X-Request-Id", "traceparent"}
+	}
+
+	return c.ExposedHeaders
+}
+
+// Zero leaves the preflight cache duration up to the browser
+func (c *CorsConfig) ParseMaxAge() time.Duration {
+	if c.MaxAge == "" {
+		return 0
+	}
+
+	duration, err := time.ParseDuration(c.MaxAge)
+
+	if err != nil {
+		panic("Could not parse CORS max age: " + c.MaxAge)
+	}
+
+	return duration
+}
+
+// Searches and cached responses are compressed with the first of the encodings the client accepts, smaller
+// responses are sent as they are
+type CompressionConfig struct {
+	Enabled   bool     `yaml:"enabled"`
+	MinSize   string   `yaml:"minSize"`
+	Encodings []string `yaml:"encodings"`
+}
+
+func (c *CompressionConfig) ParseMinSize() int64 {
+	return mustParseByteSize("compression min size", c.MinSize, 1024)
+}
+
+// In order of preference
+func (c *CompressionConfig) ParseEncodings() []string {
+	if len(c.Encodings) == 0 {
+		return []string{"br", "gzip", "zstd"}
+	}
+
+	return c.Encodings
+}
+
+// Zero means there is no limit
+func (es *ProxyHostConfig) ParseMaxRequestBodySize() int64 {
+	return mustParseByteSize("max request body size", es.MaxRequestBodySize, 0)
+}
+
+// Zero captures every body regardless of its size
+func (es *ProxyHostConfig) ParseMaxCaptureSize() int64 {
+	return mustParseByteSize("max capture size", es.MaxCaptureSize, 10*1024*1024)
+}
+
+// Compressed bodies are only decoded up to this size so a small body cannot expand into gigabytes
+func (es *ProxyHostConfig) ParseMaxDecodedSize() int64 {
+	return mustParseByteSize("max decoded size", es.MaxDecodedSize, 50*1024*1024)
+}
+
+func (es *ProxyHostConfig) ParseCacheTtl() time.Duration {
+	if es.CacheTtl == "" {
+		return 10 * time.Second
+	}
+
+	duration, err := time.ParseDuration(es.CacheTtl)
+
+	if err != nil {
+		panic("Could not parse cache ttl: " + es.CacheTtl)
+	}
+
+	return duration
+}
+
+const FilterActionInclude = "include"
+const FilterActionExclude = "exclude"
+
+// An exclude rule drops entries matching all of its conditions, an include rule drops entries that do not
+type FilterRuleConfig struct {
+	Name   string            `yaml:"name"`
+	Action string            `yaml:"action"`
+	Match  FilterMatchConfig `yaml:"match"`
+}
+
+// String conditions are regular expressions, everything that is set must match
+type FilterMatchConfig struct {
+	Type              string                  `yaml:"type"`
+	Index             string                  `yaml:"index"`
+	Host              string                  `yaml:"host"`
+	App               string                  `yaml:"app"`
+	UserAgent         string                  `yaml:"userAgent"`
+	IpCidrs           []string                `yaml:"ipCidrs"`
+	MetricCount       *NumberConditionConfig  `yaml:"metricCount"`
+	MetricCountIgnore []string                `yaml:"metricCountIgnore"`
+	Metrics           []MetricConditionConfig `yaml:"metrics"`
+}
+
+// Path is a gjson path into the metric as it is logged, eg. "minimum" for a range metric
+type MetricConditionConfig struct {
+	Name    string                 `yaml:"name"`
+	Present *bool                  `yaml:"present"`
+	Path    string                 `yaml:"path"`
+	Equals  *string                `yaml:"equals"`
+	Value   *NumberConditionConfig `yaml:"value"`
+}
+
+// Used for the Elasticsearch route when proxy.elasticsearch.filters is left out, "filters: []" disables them.
+// Searches on the dormoa index need at least 3 metrics and a search with only the default nightly price range
+// (0-9999) is not a real search
+func DefaultElasticsearchFilters() []FilterRuleConfig {
+	zero, three, one, max := 0.0, 3.0, 1.0, 9999.0
+
+	return []FilterRuleConfig{
+		{
+			Name:   "dormoa-minimum-metrics",
+			Action: "exclude",
+			Match: FilterMatchConfig{
+				Type:        "^ELASTICSEARCH$",
+				Index:       "dormoa",
+				MetricCount: &NumberConditionConfig{Lt: &three},
+			},
+		},
+		{
+			Name:   "default-nightly-low-only",
+			Action: "exclude",
+			Match: FilterMatchConfig{
+				Type:              "^ELASTICSEARCH$",
+				MetricCount:       &NumberConditionConfig{Equals: &one},
+				MetricCountIgnore: []string{"response"},
+				Metrics: []MetricConditionConfig{
+					{Name: "nightlyLow", Path: "minimum", Value: &NumberConditionConfig{Equals: &zero}},
+					{Name: "nightlyLow", Path: "maximum", Value: &NumberConditionConfig{Equals: &max}},
+				},
+			},
+		},
+	}
+}
+
+type NumberConditionConfig struct {
+	Equals *float64 `yaml:"equals"`
+	Gt     *float64 `yaml:"gt"`
+	Gte    *float64 `yaml:"gte"`
+	Lt     *float64 `yaml:"lt"`
+	Lte    *float64 `yaml:"lte"`
+}
+
+func (c *NumberConditionConfig) Matches(value float64) bool {
+	return (c.Equals == nil || value == *c.Equals) &&
+		(c.Gt == nil || value > *c.Gt) &&
+		(c.Gte == nil || value >= *c.Gte) &&
+		(c.Lt == nil || value < *c.Lt) &&
+		(c.Lte == nil || value <= *c.Lte)
 }
 
 type Credentials struct {
-	Host     string `yaml:"host"`
-	Scheme   string `yaml:"scheme"`
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
+	Host         string          `yaml:"host"`
+	Scheme       string          `yaml:"scheme"`
+	Username     string          `yaml:"username"`
+	Password     string          `yaml:"password"`
+	PasswordFile string          `yaml:"passwordFile"`
+	ApiKey       string          `yaml:"apiKey"`
+	ApiKeyFile   string          `yaml:"apiKeyFile"`
+	CloudId      string          `yaml:"cloudId"`
+	Tls          TlsClientConfig `yaml:"tls"`
 }
 
 func (c *Credentials) GetUrl() string {
+	if c.CloudId != "" {
+		return "cloud:" + c.CloudId
+	}
+
 	return c.Scheme + "://" + c.Host
 }
 
+// The index may contain a date pattern such as "es-queries-{yyyy.MM.dd}" which is evaluated against each entry's
+// timestamp, or be the name of an alias that is rolled over by ILM when writeAlias is set
 type ElasticsearchIndexQueueConfig struct {
 	Index                 string `yaml:"index"`
+	WriteAlias            bool   `yaml:"writeAlias"`
+	MappingPath           string `yaml:"mappingPath"`
 	LogBufferSize         int    `yaml:"logBufferSize"`
 	QueryDebounceDuration string `yaml:"queryDebounceDuration"`
+
+	// The changes between the debounced searches of the last N refinements are attached to the final entry, 0 disables
+	RefinementHistory int `yaml:"refinementHistory"`
+
+	// Where the debounced entries are written, defaults to the index above when empty
+	Sinks []SinkConfig `yaml:"sinks"`
+}
+
+const (
+	SinkElasticsearch = "elasticsearch"
+	SinkFile          = "file"
+	SinkStdout        = "stdout"
+	SinkWebhook       = "webhook"
+	SinkKafka         = "kafka"
+)
+
+// Only the settings for the given type are used, file rotates on size and/or interval, webhook POSTs a JSON array
+// and kafka produces each entry keyed by keyField (default "ip") so a visitor's events stay in order. The batch
+// size, flush interval and retries are shared by webhook and kafka
+type SinkConfig struct {
+	Type string `yaml:"type"`
+
+	Path           string `yaml:"path"`
+	MaxSizeMb      int    `yaml:"maxSizeMb"`
+	RotateInterval string `yaml:"rotateInterval"`
+	Compress       bool   `yaml:"compress"`
+
+	Url           string            `yaml:"url"`
+	Headers       map[string]string `yaml:"headers"`
+	BatchSize     int               `yaml:"batchSize"`
+	FlushInterval string            `yaml:"flushInterval"`
+	MaxRetries    *int              `yaml:"maxRetries"`
+
+	Brokers      []string        `yaml:"brokers"`
+	Topic        string          `yaml:"topic"`
+	KeyField     string          `yaml:"keyField"`
+	Compression  string          `yaml:"compression"`
+	RequiredAcks string          `yaml:"requiredAcks"`
+	TlsEnabled   bool            `yaml:"tlsEnabled"`
+	Tls          TlsClientConfig `yaml:"tls"`
+}
+
+// Retries are on by default, 0 turns them off
+func (c *SinkConfig) ParseMaxRetries() int {
+	if c.MaxRetries == nil {
+		return 3
+	}
+
+	return *c.MaxRetries
+}
+
+// Zero disables time based rotation
+func (c *SinkConfig) ParseRotateInterval() time.Duration {
+	if c.RotateInterval == "" {
+		return 0
+	}
+
+	duration, err := time.ParseDuration(c.RotateInterval)
+
+	if err != nil {
+		panic("Could not parse sink rotate interval: " + c.RotateInterval)
+	}
+
+	return duration
+}
+
+func (c *SinkConfig) ParseFlushInterval() time.Duration {
+	if c.FlushInterval == "" {
+		return 5 * time.Second
+	}
+
+	duration, err := time.ParseDuration(c.FlushInterval)
+
+	if err != nil {
+		panic("Could not parse sink flush interval: " + c.FlushInterval)
+	}
+
+	return duration
+}
+
+// The index is only needed when the entries are written to Elasticsearch
+func (c *ElasticsearchIndexQueueConfig) WritesToElasticsearch() bool {
+	if len(c.Sinks) == 0 {
+		return true
+	}
+
+	for _, sink := range c.Sinks {
+		if sink.Type == SinkElasticsearch {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Optional queues (eg. property views) are enabled by giving them an index or sinks
+func (c *ElasticsearchIndexQueueConfig) Enabled() bool {
+	return c.Index != "" || len(c.Sinks) > 0
 }
 
 func (c *ElasticsearchIndexQueueConfig) ParseDuration() time.Duration {
@@ -71,6 +426,98 @@ type LoggingConfig struct {
 	EsCredentials        Credentials                   `yaml:"credentials"`
 	ElasticsearchQueries ElasticsearchIndexQueueConfig `yaml:"elasticsearchQueries"`
 	LycanPriceRequests   ElasticsearchIndexQueueConfig `yaml:"lycanPriceRequests"`
+	PropertyViews        ElasticsearchIndexQueueConfig `yaml:"propertyViews"`
+	SearchResults        SearchResultsConfig           `yaml:"searchResults"`
+	Aggregations         AggregationsConfig            `yaml:"aggregations"`
+	ZeroResults          ZeroResultsConfig             `yaml:"zeroResults"`
+	Geo                  GeoConfig                     `yaml:"geo"`
+	UserAgent            UserAgentConfig               `yaml:"userAgent"`
+	Privacy              PrivacyConfig                 `yaml:"privacy"`
+	FilterDebug          FilterDebugConfig             `yaml:"filterDebug"`
+	IndexBootstrap       IndexBootstrapConfig          `yaml:"indexBootstrap"`
+}
+
+// On startup the index templates (from each queue's mappingPath) and the lifecycle policy are created if missing,
+// the rollover and retention values use the Elasticsearch units (eg. "30d", "50gb"). The zero results and filter
+// debug indices have no mapping and are not bootstrapped
+type IndexBootstrapConfig struct {
+	Enabled         bool   `yaml:"enabled"`
+	LifecyclePolicy string `yaml:"lifecyclePolicy"`
+	RolloverMaxAge  string `yaml:"rolloverMaxAge"`
+	RolloverMaxSize string `yaml:"rolloverMaxSize"`
+	DeleteAfter     string `yaml:"deleteAfter"`
+}
+
+// A fraction (0-1) of the entries rejected by the filters are written to this index
+type FilterDebugConfig struct {
+	Index         string  `yaml:"index"`
+	LogBufferSize int     `yaml:"logBufferSize"`
+	SampleRate    float64 `yaml:"sampleRate"`
+}
+
+const IpModeNone = ""
+const IpModeTruncate = "truncate"
+const IpModeHmac = "hmac"
+
+type PrivacyConfig struct {
+	IpMode       string `yaml:"ipMode"`
+	HmacKey      string `yaml:"hmacKey"`
+	HmacKeyFile  string `yaml:"hmacKeyFile"`
+	ScrubPii     bool   `yaml:"scrubPii"`
+	DropRawQuery bool   `yaml:"dropRawQuery"`
+}
+
+type UserAgentConfig struct {
+	RulesPath string `yaml:"rulesPath"`
+}
+
+type GeoConfig struct {
+	DatabasePath   string `yaml:"databasePath"`
+	ReloadInterval string `yaml:"reloadInterval"`
+}
+
+func (c *GeoConfig) ParseReloadInterval() time.Duration {
+	if c.ReloadInterval == "" {
+		return time.Minute
+	}
+
+	duration, err := time.ParseDuration(c.ReloadInterval)
+
+	if err != nil {
+		panic("Could not parse geo reload interval: " + c.ReloadInterval)
+	}
+
+	return duration
+}
+
+type AggregationsConfig struct {
+	TopBuckets int `yaml:"topBuckets"`
+}
+
+type ZeroResultsConfig struct {
+	Index         string `yaml:"index"`
+	LogBufferSize int    `yaml:"logBufferSize"`
+	TopN          int    `yaml:"topN"`
+	Window        string `yaml:"window"`
+}
+
+func (c *ZeroResultsConfig) ParseWindow() time.Duration {
+	if c.Window == "" {
+		return 24 * time.Hour
+	}
+
+	duration, err := time.ParseDuration(c.Window)
+
+	if err != nil {
+		panic("Could not parse zero results window: " + c.Window)
+	}
+
+	return duration
+}
+
+type SearchResultsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	MaxHits int  `yaml:"maxHits"`
 }
 
 func (s *ServerConfig) IsTlsValid() bool {
@@ -124,5 +571,21 @@ func loadFromReader(reader io.Reader) (Config, error) {
 		return Config{}, err
 	}
 
+	if err := InterpolateEnv(&config); err != nil {
+		return Config{}, err
+	}
+
+	if err := ApplyEnvOverrides(&config); err != nil {
+		return Config{}, err
+	}
+
+	if err := ResolveSecretFiles(&config); err != nil {
+		return Config{}, err
+	}
+
+	if config.Proxy.Elasticsearch.Filters == nil {
+		config.Proxy.Elasticsearch.Filters = DefaultElasticsearchFilters()
+	}
+
 	return config, nil
 }
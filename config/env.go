@@ -0,0 +1,195 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Prefix for the environment override layer, eg. ZAZU_LOGGING_CREDENTIALS_PASSWORD sets logging.credentials.password
+const EnvOverridePrefix = "ZAZU"
+
+// Only the braced form is supported so regular expressions in the filters ("^INDEX$") are left alone
+var envReferenceRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// Replaces ${VAR} and ${VAR:-default} references in every string value of the parsed config (so comments are
+// never touched), it is an error to reference an unset variable without a default
+func InterpolateEnv(config *Config) error {
+	missing := make([]string, 0)
+
+	interpolateValue(reflect.ValueOf(config).Elem(), &missing)
+
+	if len(missing) > 0 {
+		return fmt.Errorf("config references unset environment variables: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+func interpolateValue(value reflect.Value, missing *[]string) {
+	switch value.Kind() {
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if value.Type().Field(i).PkgPath == "" {
+				interpolateValue(value.Field(i), missing)
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			interpolateValue(value.Index(i), missing)
+		}
+	case reflect.Ptr:
+		if !value.IsNil() {
+			interpolateValue(value.Elem(), missing)
+		}
+	case reflect.Map:
+		for _, key := range value.MapKeys() {
+			if entry := value.MapIndex(key); entry.Kind() == reflect.String {
+				value.SetMapIndex(key, reflect.ValueOf(interpolateString(entry.String(), missing)))
+			}
+		}
+	case reflect.String:
+		value.SetString(interpolateString(value.String(), missing))
+	}
+}
+
+func interpolateString(value string, missing *[]string) string {
+	return envReferenceRegex.ReplaceAllStringFunc(value, func(match string) string {
+		parts := envReferenceRegex.FindStringSubmatch(match)
+
+		if env, exists := os.LookupEnv(parts[1]); exists {
+			return env
+		}
+
+		if strings.Contains(match, ":-") {
+			return parts[2]
+		}
+
+		*missing = append(*missing, parts[1])
+
+		return match
+	})
+}
+
+// Walks the config using the YAML names and sets any scalar field that has a matching environment variable
+func ApplyEnvOverrides(config *Config) error {
+	return applyEnvOverrides(reflect.ValueOf(config).Elem(), EnvOverridePrefix)
+}
+
+func applyEnvOverrides(value reflect.Value, prefix string) error {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+
+		if name == "" || name == "-" {
+			continue
+		}
+
+		key := prefix + "_" + strings.ToUpper(name)
+		target := value.Field(i)
+
+		if target.Kind() == reflect.Struct {
+			if err := applyEnvOverrides(target, key); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		env, exists := os.LookupEnv(key)
+
+		if !exists {
+			continue
+		}
+
+		if err := setFromString(target, env); err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+	}
+
+	return nil
+}
+
+func setFromString(target reflect.Value, value string) error {
+	switch target.Kind() {
+	case reflect.String:
+		target.SetString(value)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		target.SetBool(parsed)
+	case reflect.Int:
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		target.SetInt(int64(parsed))
+	case reflect.Float64:
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		target.SetFloat(parsed)
+	case reflect.Slice:
+		if target.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("can not be set from the environment")
+		}
+
+		// Comma separated, eg. ZAZU_SERVER_TRUSTEDPROXIES=10.0.0.0/8,127.0.0.1
+		items := make([]string, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		target.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("can not be set from the environment")
+	}
+
+	return nil
+}
+
+// Secrets can be referenced by file (eg. docker/kubernetes secrets) instead of being written in the config
+func ResolveSecretFiles(config *Config) error {
+	secrets := map[string]struct {
+		file   string
+		target *string
+	}{
+		"logging.credentials.passwordFile": {config.Logging.EsCredentials.PasswordFile, &config.Logging.EsCredentials.Password},
+		"logging.credentials.apiKeyFile":   {config.Logging.EsCredentials.ApiKeyFile, &config.Logging.EsCredentials.ApiKey},
+		"logging.privacy.hmacKeyFile":      {config.Logging.Privacy.HmacKeyFile, &config.Logging.Privacy.HmacKey},
+		"server.admin.tokenFile":           {config.Server.Admin.TokenFile, &config.Server.Admin.Token},
+	}
+
+	paths := make([]string, 0, len(secrets))
+	for path := range secrets {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		secret := secrets[path]
+
+		if secret.file == "" {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(secret.file)
+
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+
+		*secret.target = strings.TrimRight(string(content), "\r\n")
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEnvInterpolationAndOverrides(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zazu-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secretFile := filepath.Join(dir, "hmac")
+	if err := ioutil.WriteFile(secretFile, []byte("file-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("TEST_ZAZU_ES_HOST", "es.internal:9200")
+	os.Setenv("ZAZU_LOGGING_CREDENTIALS_PASSWORD", "from-env")
+	os.Setenv("ZAZU_LOGGING_ELASTICSEARCHQUERIES_LOGBUFFERSIZE", "50")
+	os.Setenv("ZAZU_SERVER_TRUSTEDPROXIES", "10.0.0.0/8, 127.0.0.1")
+	defer func() {
+		os.Unsetenv("TEST_ZAZU_ES_HOST")
+		os.Unsetenv("ZAZU_LOGGING_CREDENTIALS_PASSWORD")
+		os.Unsetenv("ZAZU_LOGGING_ELASTICSEARCHQUERIES_LOGBUFFERSIZE")
+		os.Unsetenv("ZAZU_SERVER_TRUSTEDPROXIES")
+	}()
+
+	yaml := `
+proxy:
+  elasticsearch:
+    host: "${TEST_ZAZU_ES_HOST}"
+    scheme: "${TEST_ZAZU_UNSET_SCHEME:-https}"
+    filters:
+      - name: "regex is untouched"
+        action: "exclude"
+        match:
+          type: "^PROPERTY_VIEW$"
+logging:
+  credentials:
+    password: "plaintext"
+  elasticsearchQueries:
+    logBufferSize: 20
+  privacy:
+    hmacKeyFile: "` + secretFile + `"
+`
+
+	cfg, err := loadFromReader(strings.NewReader(yaml))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Proxy.Elasticsearch.Host != "es.internal:9200" || cfg.Proxy.Elasticsearch.Scheme != "https" {
+		t.Errorf("interpolation failed: %+v", cfg.Proxy.Elasticsearch)
+	}
+
+	if cfg.Proxy.Elasticsearch.Filters[0].Match.Type != "^PROPERTY_VIEW$" {
+		t.Errorf("regex was modified: %s", cfg.Proxy.Elasticsearch.Filters[0].Match.Type)
+	}
+
+	if cfg.Logging.EsCredentials.Password != "from-env" || cfg.Logging.ElasticsearchQueries.LogBufferSize != 50 {
+		t.Errorf("env overrides not applied: %+v %+v", cfg.Logging.EsCredentials, cfg.Logging.ElasticsearchQueries)
+	}
+
+	if len(cfg.Server.TrustedProxies) != 2 || cfg.Server.TrustedProxies[1] != "127.0.0.1" {
+		t.Errorf("list override not applied: %v", cfg.Server.TrustedProxies)
+	}
+
+	if cfg.Logging.Privacy.HmacKey != "file-secret" {
+		t.Errorf("secret file not resolved: %q", cfg.Logging.Privacy.HmacKey)
+	}
+
+	t.Run("unset variables are an error", func(t *testing.T) {
+		if _, err := loadFromReader(strings.NewReader(`server: {host: "${TEST_ZAZU_UNSET}"}`)); err == nil {
+			t.Fail()
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var byteSizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"GB", 1024 * 1024 * 1024},
+	{"MB", 1024 * 1024},
+	{"KB", 1024},
+	{"B", 1},
+}
+
+// ParseByteSize reads sizes such as "512KB", "10MB" or "1GB" (powers of 1024), a plain number is in bytes
+func ParseByteSize(value string) (int64, error) {
+	trimmed := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(trimmed, unit.suffix) {
+			trimmed = strings.TrimSpace(strings.TrimSuffix(trimmed, unit.suffix))
+			multiplier = unit.multiplier
+
+			break
+		}
+	}
+
+	size, err := strconv.ParseFloat(trimmed, 64)
+
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size %q (eg. \"512KB\", \"10MB\")", value)
+	}
+
+	return int64(size * float64(multiplier)), nil
+}
+
+func mustParseByteSize(name string, value string, defaultSize int64) int64 {
+	if value == "" {
+		return defaultSize
+	}
+
+	size, err := ParseByteSize(value)
+
+	if err != nil {
+		panic("Could not parse " + name + ": " + value)
+	}
+
+	return size
+}
@@ -0,0 +1,36 @@
+package config
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int64
+		valid    bool
+	}{
+		{"512", 512, true},
+		{"512B", 512, true},
+		{"64KB", 64 * 1024, true},
+		{"10MB", 10 * 1024 * 1024, true},
+		{"1.5mb", 1536 * 1024, true},
+		{"1GB", 1024 * 1024 * 1024, true},
+		{"10 MB", 10 * 1024 * 1024, true},
+		{"ten", 0, false},
+		{"-1MB", 0, false},
+		{"", 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			size, err := ParseByteSize(test.value)
+
+			if (err == nil) != test.valid {
+				t.Fatalf("expected valid %v, got error %v", test.valid, err)
+			}
+
+			if size != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, size)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// Client side TLS for the upstreams and the logging cluster, certificates are verified unless skipping is
+// explicitly enabled
+type TlsClientConfig struct {
+	CaPath             string `yaml:"caPath"`
+	CertificatePath    string `yaml:"certificatePath"`
+	PrivateKeyPath     string `yaml:"privateKeyPath"`
+	ServerName         string `yaml:"serverName"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+func (c *TlsClientConfig) BuildTlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CaPath != "" {
+		pem, err := ioutil.ReadFile(c.CaPath)
+
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA bundle: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle: %s", c.CaPath)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	// Client certificate for mTLS
+	if c.CertificatePath != "" || c.PrivateKeyPath != "" {
+		certificate, err := tls.LoadX509KeyPair(c.CertificatePath, c.PrivateKeyPath)
+
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %v", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,440 @@
+package config
+
+import (
+	"fmt"
+	"github.com/apex/log"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+// All of the problems are collected so they can be fixed in one go rather than one restart at a time
+type ValidationErrors []ValidationError
+
+func (ve ValidationErrors) Error() string {
+	lines := make([]string, 0, len(ve))
+
+	for _, err := range ve {
+		lines = append(lines, fmt.Sprintf("%s: %s", err.Path, err.Message))
+	}
+
+	return "invalid config:\n  " + strings.Join(lines, "\n  ")
+}
+
+func (ve *ValidationErrors) add(path string, format string, args ...interface{}) {
+	*ve = append(*ve, ValidationError{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (ve *ValidationErrors) required(path string, value string) {
+	if value == "" {
+		ve.add(path, "is required")
+	}
+}
+
+func (ve *ValidationErrors) duration(path string, value string, required bool) {
+	if value == "" {
+		if required {
+			ve.add(path, "is required")
+		}
+
+		return
+	}
+
+	if _, err := time.ParseDuration(value); err != nil {
+		ve.add(path, "invalid duration %q (eg. \"3000ms\", \"10s\")", value)
+	}
+}
+
+// Intervals used for a ticker, which panics on zero or a negative duration
+func (ve *ValidationErrors) positiveDuration(path string, value string) {
+	if value == "" {
+		return
+	}
+
+	if parsed, err := time.ParseDuration(value); err != nil {
+		ve.add(path, "invalid duration %q (eg. \"3000ms\", \"10s\")", value)
+	} else if parsed <= 0 {
+		ve.add(path, "must be greater than 0, got %q", value)
+	}
+}
+
+func (ve *ValidationErrors) byteSize(path string, value string) {
+	if value == "" {
+		return
+	}
+
+	if _, err := ParseByteSize(value); err != nil {
+		ve.add(path, "%v", err)
+	}
+}
+
+func (ve *ValidationErrors) scheme(path string, value string) {
+	if value != "http" && value != "https" {
+		ve.add(path, "must be \"http\" or \"https\", got %q", value)
+	}
+}
+
+func (ve *ValidationErrors) host(path string, value string) {
+	if value == "" {
+		ve.add(path, "is required")
+		return
+	}
+
+	if strings.Contains(value, "://") || strings.Contains(value, "/") {
+		ve.add(path, "must be a host with an optional port, not a URL: %q", value)
+	}
+}
+
+func (ve *ValidationErrors) fileExists(path string, value string) {
+	if value == "" {
+		return
+	}
+
+	if info, err := os.Stat(value); err != nil {
+		ve.add(path, "file %q does not exist or is not readable", value)
+	} else if info.IsDir() {
+		ve.add(path, "%q is a directory", value)
+	}
+}
+
+func (ve *ValidationErrors) regex(path string, value string) {
+	if value == "" {
+		return
+	}
+
+	if _, err := regexp.Compile(value); err != nil {
+		ve.add(path, "invalid regular expression %q: %v", value, err)
+	}
+}
+
+func (ve *ValidationErrors) cidr(path string, value string) {
+	if net.ParseIP(value) != nil {
+		return
+	}
+
+	if _, _, err := net.ParseCIDR(value); err != nil {
+		ve.add(path, "invalid IP address or CIDR %q", value)
+	}
+}
+
+var indexDatePattern = regexp.MustCompile(`\{([^{}]*)\}`)
+var indexDateTokens = regexp.MustCompile(`^(yyyy|yy|MM|dd|HH|ww|[-._])+$`)
+
+func (ve *ValidationErrors) indexName(path string, value string, writeAlias bool) {
+	patterns := indexDatePattern.FindAllStringSubmatch(value, -1)
+
+	if len(patterns) > 0 && writeAlias {
+		ve.add(path, "cannot contain a date pattern when writing via an alias, got %q", value)
+
+		return
+	}
+
+	for _, pattern := range patterns {
+		if !indexDateTokens.MatchString(pattern[1]) {
+			ve.add(path, "date pattern {%s} may only use yyyy, yy, MM, dd, HH, ww and separators", pattern[1])
+		}
+	}
+
+	if strings.ContainsAny(indexDatePattern.ReplaceAllString(value, ""), "{}") {
+		ve.add(path, "has an unbalanced date pattern, got %q", value)
+	}
+}
+
+func (ve *ValidationErrors) notNegative(path string, value int) {
+	if value < 0 {
+		ve.add(path, "must not be negative, got %d", value)
+	}
+}
+
+// Checks everything that would otherwise fail (or panic) later on when the value is used
+func (c *Config) Validate() error {
+	ve := ValidationErrors{}
+
+	c.validateServer(&ve)
+	c.validateProxy(&ve)
+	c.validateLogging(&ve)
+
+	if len(ve) > 0 {
+		sort.SliceStable(ve, func(i, j int) bool {
+			return ve[i].Path < ve[j].Path
+		})
+
+		return ve
+	}
+
+	return nil
+}
+
+func (c *Config) validateServer(ve *ValidationErrors) {
+	ve.required("server.address", c.Server.Address)
+
+	if _, _, err := net.SplitHostPort(c.Server.Address); c.Server.Address != "" && err != nil {
+		ve.add("server.address", "must be in the form \"host:port\" or \":port\", got %q", c.Server.Address)
+	}
+
+	if c.Server.Tls.Enabled {
+		if c.Server.Tls.UseLetsEncrypt {
+			ve.required("server.host", c.Server.Host)
+			ve.required("server.tls.email", c.Server.Tls.Email)
+		} else {
+			ve.required("server.tls.certificatePath", c.Server.Tls.CertificatePath)
+			ve.required("server.tls.privateKeyPath", c.Server.Tls.PrivateKeyPath)
+			ve.fileExists("server.tls.certificatePath", c.Server.Tls.CertificatePath)
+			ve.fileExists("server.tls.privateKeyPath", c.Server.Tls.PrivateKeyPath)
+		}
+	}
+
+	// The tokenFile has already been read into the token
+	if c.Server.Admin.Enabled && c.Server.Admin.Token == "" {
+		ve.add("server.admin.token", "is required when the admin endpoints are enabled (or set tokenFile)")
+	}
+
+	for i, cidr := range c.Server.TrustedProxies {
+		ve.cidr(fmt.Sprintf("server.trustedProxies[%d]", i), cidr)
+	}
+
+	switch strings.ToLower(c.Server.ClientIpHeader) {
+	case "", strings.ToLower(ClientIpXForwardedFor), strings.ToLower(ClientIpForwarded), strings.ToLower(ClientIpXRealIp):
+	default:
+		ve.add("server.clientIpHeader", "must be %s, %s or %s, got %q", ClientIpXForwardedFor, ClientIpForwarded, ClientIpXRealIp, c.Server.ClientIpHeader)
+	}
+}
+
+func (c *Config) validateProxy(ve *ValidationErrors) {
+	hosts := map[string]ProxyHostConfig{
+		"proxy.elasticsearch": c.Proxy.Elasticsearch,
+		"proxy.lycan":         c.Proxy.Lycan,
+	}
+
+	for path, host := range hosts {
+		ve.scheme(path+".scheme", host.Scheme)
+		ve.host(path+".host", host.Host)
+		ve.duration(path+".cacheTtl", host.CacheTtl, false)
+		ve.byteSize(path+".maxRequestBodySize", host.MaxRequestBodySize)
+		ve.byteSize(path+".maxCaptureSize", host.MaxCaptureSize)
+		ve.byteSize(path+".maxDecodedSize", host.MaxDecodedSize)
+		validateTlsClient(ve, path+".tls", host.Tls)
+		validateCompression(ve, path+".compression", host.Compression)
+		validateCors(ve, path+".cors", host.Cors)
+
+		for i, rule := range host.Filters {
+			validateFilterRule(ve, fmt.Sprintf("%s.filters[%d]", path, i), rule)
+		}
+	}
+}
+
+func validateCompression(ve *ValidationErrors, path string, compression CompressionConfig) {
+	ve.byteSize(path+".minSize", compression.MinSize)
+
+	for i, encoding := range compression.Encodings {
+		switch encoding {
+		case "br", "gzip", "zstd", "deflate":
+		default:
+			ve.add(fmt.Sprintf("%s.encodings[%d]", path, i), "must be br, gzip, zstd or deflate, got %q", encoding)
+		}
+	}
+}
+
+func validateCors(ve *ValidationErrors, path string, cors CorsConfig) {
+	ve.duration(path+".maxAge", cors.MaxAge, false)
+
+	// Echoing any origin with credentials would let every site make authenticated requests, this includes the
+	// default of allowing any origin when none are listed
+	if cors.AllowCredentials && cors.AllowsAnyOrigin() {
+		ve.add(path+".allowCredentials", "cannot be used when any origin is allowed, list the allowedOrigins instead of \"*\" or leaving them empty")
+	}
+
+	for i, origin := range cors.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+
+		originPath := fmt.Sprintf("%s.allowedOrigins[%d]", path, i)
+
+		parsed, err := url.Parse(strings.Replace(origin, "://*.", "://", 1))
+
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" || parsed.Path != "" || strings.Contains(parsed.Host, "*") {
+			ve.add(originPath, "must be \"*\" or an origin such as \"https://www.example.com\" or \"https://*.example.com\", got %q", origin)
+		}
+	}
+
+	for i, pattern := range cors.AllowedOriginPatterns {
+		ve.regex(fmt.Sprintf("%s.allowedOriginPatterns[%d]", path, i), pattern)
+	}
+}
+
+func validateFilterRule(ve *ValidationErrors, path string, rule FilterRuleConfig) {
+	if rule.Action != FilterActionInclude && rule.Action != FilterActionExclude {
+		ve.add(path+".action", "must be %q or %q, got %q", FilterActionInclude, FilterActionExclude, rule.Action)
+	}
+
+	ve.regex(path+".match.type", rule.Match.Type)
+	ve.regex(path+".match.index", rule.Match.Index)
+	ve.regex(path+".match.host", rule.Match.Host)
+	ve.regex(path+".match.app", rule.Match.App)
+	ve.regex(path+".match.userAgent", rule.Match.UserAgent)
+
+	for i, cidr := range rule.Match.IpCidrs {
+		ve.cidr(fmt.Sprintf("%s.match.ipCidrs[%d]", path, i), cidr)
+	}
+
+	for i, metric := range rule.Match.Metrics {
+		metricPath := fmt.Sprintf("%s.match.metrics[%d]", path, i)
+
+		ve.required(metricPath+".name", metric.Name)
+
+		if (metric.Equals != nil || metric.Value != nil) && metric.Path == "" {
+			ve.add(metricPath+".path", "is required when comparing a value")
+		}
+	}
+}
+
+func validateSink(ve *ValidationErrors, path string, sink SinkConfig) {
+	switch sink.Type {
+	case SinkKafka:
+		if len(sink.Brokers) == 0 {
+			ve.add(path+".brokers", "is required")
+		}
+
+		for i, broker := range sink.Brokers {
+			ve.host(fmt.Sprintf("%s.brokers[%d]", path, i), broker)
+		}
+
+		ve.required(path+".topic", sink.Topic)
+		ve.notNegative(path+".batchSize", sink.BatchSize)
+		ve.notNegative(path+".maxRetries", sink.ParseMaxRetries())
+		ve.positiveDuration(path+".flushInterval", sink.FlushInterval)
+		validateTlsClient(ve, path+".tls", sink.Tls)
+
+		switch sink.Compression {
+		case "", "none", "gzip", "snappy", "lz4", "zstd":
+		default:
+			ve.add(path+".compression", "must be none, gzip, snappy, lz4 or zstd, got %q", sink.Compression)
+		}
+
+		switch sink.RequiredAcks {
+		case "", "none", "leader", "all":
+		default:
+			ve.add(path+".requiredAcks", "must be none, leader or all, got %q", sink.RequiredAcks)
+		}
+	case SinkElasticsearch, SinkStdout:
+	case SinkFile:
+		ve.required(path+".path", sink.Path)
+		ve.notNegative(path+".maxSizeMb", sink.MaxSizeMb)
+		ve.duration(path+".rotateInterval", sink.RotateInterval, false)
+	case SinkWebhook:
+		ve.required(path+".url", sink.Url)
+
+		if parsed, err := url.Parse(sink.Url); sink.Url != "" && (err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "") {
+			ve.add(path+".url", "must be an http or https URL, got %q", sink.Url)
+		}
+
+		ve.notNegative(path+".batchSize", sink.BatchSize)
+		ve.notNegative(path+".maxRetries", sink.ParseMaxRetries())
+		ve.positiveDuration(path+".flushInterval", sink.FlushInterval)
+	default:
+		ve.add(path+".type", "must be one of %s, %s, %s, %s or %s, got %q", SinkElasticsearch, SinkFile, SinkStdout, SinkWebhook, SinkKafka, sink.Type)
+	}
+}
+
+func validateTlsClient(ve *ValidationErrors, path string, tls TlsClientConfig) {
+	ve.fileExists(path+".caPath", tls.CaPath)
+	ve.fileExists(path+".certificatePath", tls.CertificatePath)
+	ve.fileExists(path+".privateKeyPath", tls.PrivateKeyPath)
+
+	if (tls.CertificatePath == "") != (tls.PrivateKeyPath == "") {
+		ve.add(path, "certificatePath and privateKeyPath must be set together")
+	}
+}
+
+func (c *Config) validateLogging(ve *ValidationErrors) {
+	if _, err := log.ParseLevel(c.Logging.Level); err != nil {
+		ve.add("logging.level", "must be one of debug, info, warn, error or fatal, got %q", c.Logging.Level)
+	}
+
+	// Cloud deployments are addressed by their id instead
+	if c.Logging.EsCredentials.CloudId == "" {
+		ve.scheme("logging.credentials.scheme", c.Logging.EsCredentials.Scheme)
+		ve.host("logging.credentials.host", c.Logging.EsCredentials.Host)
+	} else if c.Logging.EsCredentials.Host != "" {
+		ve.add("logging.credentials.host", "must be empty when cloudId is set")
+	}
+
+	validateTlsClient(ve, "logging.credentials.tls", c.Logging.EsCredentials.Tls)
+
+	// The main queues are always needed, the others are only enabled when they have an index
+	queues := map[string]ElasticsearchIndexQueueConfig{
+		"logging.elasticsearchQueries": c.Logging.ElasticsearchQueries,
+		"logging.lycanPriceRequests":   c.Logging.LycanPriceRequests,
+	}
+
+	if c.Logging.PropertyViews.Enabled() {
+		queues["logging.propertyViews"] = c.Logging.PropertyViews
+	}
+
+	for path, queue := range queues {
+		if queue.WritesToElasticsearch() {
+			ve.required(path+".index", queue.Index)
+		}
+
+		for i, sink := range queue.Sinks {
+			validateSink(ve, fmt.Sprintf("%s.sinks[%d]", path, i), sink)
+		}
+
+		ve.indexName(path+".index", queue.Index, queue.WriteAlias)
+		ve.fileExists(path+".mappingPath", queue.MappingPath)
+
+		if queue.WriteAlias && c.Logging.IndexBootstrap.Enabled && c.Logging.IndexBootstrap.LifecyclePolicy == "" {
+			ve.add(path+".writeAlias", "requires logging.indexBootstrap.lifecyclePolicy so the alias is rolled over")
+		}
+		ve.notNegative(path+".logBufferSize", queue.LogBufferSize)
+		ve.duration(path+".queryDebounceDuration", queue.QueryDebounceDuration, true)
+		ve.notNegative(path+".refinementHistory", queue.RefinementHistory)
+	}
+
+	ve.notNegative("logging.searchResults.maxHits", c.Logging.SearchResults.MaxHits)
+	ve.notNegative("logging.aggregations.topBuckets", c.Logging.Aggregations.TopBuckets)
+
+	ve.notNegative("logging.zeroResults.logBufferSize", c.Logging.ZeroResults.LogBufferSize)
+	ve.notNegative("logging.zeroResults.topN", c.Logging.ZeroResults.TopN)
+	ve.duration("logging.zeroResults.window", c.Logging.ZeroResults.Window, false)
+
+	ve.fileExists("logging.geo.databasePath", c.Logging.Geo.DatabasePath)
+	ve.duration("logging.geo.reloadInterval", c.Logging.Geo.ReloadInterval, false)
+
+	ve.fileExists("logging.userAgent.rulesPath", c.Logging.UserAgent.RulesPath)
+
+	switch c.Logging.Privacy.IpMode {
+	case IpModeNone, IpModeTruncate:
+	case IpModeHmac:
+		ve.required("logging.privacy.hmacKey", c.Logging.Privacy.HmacKey)
+	default:
+		ve.add("logging.privacy.ipMode", "must be empty, %q or %q, got %q", IpModeTruncate, IpModeHmac, c.Logging.Privacy.IpMode)
+	}
+
+	ve.indexName("logging.zeroResults.index", c.Logging.ZeroResults.Index, false)
+	ve.indexName("logging.filterDebug.index", c.Logging.FilterDebug.Index, false)
+
+	bootstrap := c.Logging.IndexBootstrap
+	if bootstrap.LifecyclePolicy == "" && (bootstrap.RolloverMaxAge != "" || bootstrap.RolloverMaxSize != "" || bootstrap.DeleteAfter != "") {
+		ve.add("logging.indexBootstrap.lifecyclePolicy", "is required when rollover or deletion is configured")
+	}
+
+	ve.notNegative("logging.filterDebug.logBufferSize", c.Logging.FilterDebug.LogBufferSize)
+
+	if c.Logging.FilterDebug.SampleRate < 0 || c.Logging.FilterDebug.SampleRate > 1 {
+		ve.add("logging.filterDebug.sampleRate", "must be between 0 and 1, got %v", c.Logging.FilterDebug.SampleRate)
+	}
+}
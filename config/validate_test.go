@@ -0,0 +1,138 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+const validConfig = `
+server:
+  address: ":9243"
+proxy:
+  lycan:
+    scheme: "https"
+    host: "lycan.example.com"
+  elasticsearch:
+    scheme: "https"
+    host: "localhost:9243"
+logging:
+  level: "error"
+  credentials:
+    scheme: "https"
+    host: "localhost:9243"
+  elasticsearchQueries:
+    index: "es-queries"
+    queryDebounceDuration: "3000ms"
+  lycanPriceRequests:
+    index: "price-requests"
+    queryDebounceDuration: "3000ms"
+`
+
+func TestValidate(t *testing.T) {
+	t.Run("valid config passes", func(t *testing.T) {
+		cfg, err := loadFromReader(strings.NewReader(validConfig))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := cfg.Validate(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("default filters apply unless filters are set", func(t *testing.T) {
+		cfg, err := loadFromReader(strings.NewReader(validConfig))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(cfg.Proxy.Elasticsearch.Filters) != 2 || cfg.Proxy.Elasticsearch.Filters[0].Name != "dormoa-minimum-metrics" {
+			t.Errorf("expected the default filters, got %+v", cfg.Proxy.Elasticsearch.Filters)
+		}
+
+		if len(cfg.Proxy.Lycan.Filters) != 0 {
+			t.Errorf("expected no lycan filters, got %+v", cfg.Proxy.Lycan.Filters)
+		}
+
+		disabled := strings.Replace(validConfig, `host: "localhost:9243"
+logging:`, `host: "localhost:9243"
+    filters: []
+logging:`, 1)
+
+		cfg, err = loadFromReader(strings.NewReader(disabled))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cfg.Proxy.Elasticsearch.Filters == nil || len(cfg.Proxy.Elasticsearch.Filters) != 0 {
+			t.Errorf("expected the filters to be disabled, got %+v", cfg.Proxy.Elasticsearch.Filters)
+		}
+	})
+
+	t.Run("all problems are reported with their paths", func(t *testing.T) {
+		cfg, err := loadFromReader(strings.NewReader(validConfig))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cfg.Proxy.Elasticsearch.Scheme = "ftp"
+		cfg.Logging.ElasticsearchQueries.QueryDebounceDuration = "3 seconds"
+		cfg.Logging.LycanPriceRequests.Index = ""
+		cfg.Server.Tls = ServerTlsConfig{Enabled: true, CertificatePath: "/does/not/exist.pem"}
+		cfg.Proxy.Lycan.Filters = []FilterRuleConfig{{Action: "drop", Match: FilterMatchConfig{Index: "("}}}
+		cfg.Proxy.Lycan.Compression = CompressionConfig{Enabled: true, Encodings: []string{"gzip", "lzma"}}
+		cfg.Proxy.Elasticsearch.Cors = CorsConfig{AllowCredentials: true}
+		cfg.Server.Admin = AdminConfig{Enabled: true}
+		cfg.Server.ClientIpHeader = "True-Client-IP"
+		cfg.Proxy.Lycan.Cors = CorsConfig{AllowedOrigins: []string{"https://*.example.com", "*", "example.com", "https://foo*.example.com"}, AllowCredentials: true}
+		cfg.Logging.ZeroResults.Index = "zero-results-{yyyy.MMM}"
+		cfg.Logging.ElasticsearchQueries.Sinks = []SinkConfig{{Type: "syslog"}, {Type: SinkWebhook, Url: "example.com/hook", FlushInterval: "0s"}, {Type: SinkKafka, Compression: "brotli", FlushInterval: "-1s"}}
+
+		err = cfg.Validate()
+		errors, ok := err.(ValidationErrors)
+
+		if !ok {
+			t.Fatalf("expected ValidationErrors, got %v", err)
+		}
+
+		expected := []string{
+			"logging.elasticsearchQueries.queryDebounceDuration",
+			"logging.elasticsearchQueries.sinks[0].type",
+			"logging.elasticsearchQueries.sinks[1].flushInterval",
+			"logging.elasticsearchQueries.sinks[1].url",
+			"logging.elasticsearchQueries.sinks[2].brokers",
+			"logging.elasticsearchQueries.sinks[2].compression",
+			"logging.elasticsearchQueries.sinks[2].flushInterval",
+			"logging.elasticsearchQueries.sinks[2].topic",
+			"logging.lycanPriceRequests.index",
+			"logging.zeroResults.index",
+			"proxy.elasticsearch.cors.allowCredentials",
+			"proxy.elasticsearch.scheme",
+			"proxy.lycan.compression.encodings[1]",
+			"proxy.lycan.cors.allowCredentials",
+			"proxy.lycan.cors.allowedOrigins[2]",
+			"proxy.lycan.cors.allowedOrigins[3]",
+			"proxy.lycan.filters[0].action",
+			"proxy.lycan.filters[0].match.index",
+			"server.admin.token",
+			"server.clientIpHeader",
+			"server.tls.certificatePath",
+			"server.tls.privateKeyPath",
+		}
+
+		paths := make(map[string]bool)
+		for _, e := range errors {
+			paths[e.Path] = true
+		}
+
+		for _, path := range expected {
+			if !paths[path] {
+				t.Errorf("expected an error for %s, got:\n%v", path, err)
+			}
+		}
+	})
+}
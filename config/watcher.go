@@ -0,0 +1,142 @@
+package config
+
+import (
+	"github.com/apex/log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/*
+ * The config is re-read on SIGHUP or when the file is modified, the parts that can be changed at runtime are
+ * applied by the handlers registered with OnReload. Anything else is logged as requiring a restart
+ */
+
+const DefaultWatchInterval = 5 * time.Second
+
+var reloadHandlers []func(previous Config, current Config)
+var reloadMutex sync.Mutex
+
+func OnReload(handler func(previous Config, current Config)) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	reloadHandlers = append(reloadHandlers, handler)
+}
+
+// Watch blocks, so should be started in its own go routine
+func Watch(name string, current Config, interval time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	modTime := getModTime(name)
+
+	for {
+		select {
+		case <-signals:
+			log.Info("Received SIGHUP, reloading config: " + name)
+		case <-time.After(interval):
+			latest := getModTime(name)
+
+			if latest.Equal(modTime) {
+				continue
+			}
+
+			log.Info("Config file changed, reloading: " + name)
+		}
+
+		modTime = getModTime(name)
+
+		reloaded, err := Reload(name, current)
+
+		if err != nil {
+			log.Error("Config reload failed, keeping the current config: " + err.Error())
+			continue
+		}
+
+		current = reloaded
+	}
+}
+
+func Reload(name string, current Config) (Config, error) {
+	cfg, err := LoadFromFile(name)
+
+	if err != nil {
+		return current, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return current, err
+	}
+
+	for _, setting := range RestartRequiredChanges(current, cfg) {
+		log.Warn("Config setting changed but requires a restart to take effect: " + setting)
+	}
+
+	reloadMutex.Lock()
+	handlers := reloadHandlers
+	reloadMutex.Unlock()
+
+	for _, handler := range handlers {
+		handler(current, cfg)
+	}
+
+	return cfg, nil
+}
+
+// Lists the settings that differ but can not be applied at runtime
+func RestartRequiredChanges(previous Config, current Config) []string {
+	changes := make([]string, 0)
+
+	settings := map[string][2]interface{}{
+		"server":                             {previous.Server, current.Server},
+		"proxy.elasticsearch.host":           {previous.Proxy.Elasticsearch.Host, current.Proxy.Elasticsearch.Host},
+		"proxy.elasticsearch.scheme":         {previous.Proxy.Elasticsearch.Scheme, current.Proxy.Elasticsearch.Scheme},
+		"proxy.elasticsearch.tls":            {previous.Proxy.Elasticsearch.Tls, current.Proxy.Elasticsearch.Tls},
+		"proxy.lycan.host":                   {previous.Proxy.Lycan.Host, current.Proxy.Lycan.Host},
+		"proxy.lycan.scheme":                 {previous.Proxy.Lycan.Scheme, current.Proxy.Lycan.Scheme},
+		"proxy.lycan.tls":                    {previous.Proxy.Lycan.Tls, current.Proxy.Lycan.Tls},
+		"logging.credentials":                {previous.Logging.EsCredentials, current.Logging.EsCredentials},
+		"logging.elasticsearchQueries.index": {previous.Logging.ElasticsearchQueries.Index, current.Logging.ElasticsearchQueries.Index},
+		"logging.lycanPriceRequests.index":   {previous.Logging.LycanPriceRequests.Index, current.Logging.LycanPriceRequests.Index},
+		"logging.propertyViews.index":        {previous.Logging.PropertyViews.Index, current.Logging.PropertyViews.Index},
+		"logging.elasticsearchQueries.sinks": {previous.Logging.ElasticsearchQueries.Sinks, current.Logging.ElasticsearchQueries.Sinks},
+		"logging.lycanPriceRequests.sinks":   {previous.Logging.LycanPriceRequests.Sinks, current.Logging.LycanPriceRequests.Sinks},
+		"logging.propertyViews.sinks":        {previous.Logging.PropertyViews.Sinks, current.Logging.PropertyViews.Sinks},
+		"logging.searchResults":              {previous.Logging.SearchResults, current.Logging.SearchResults},
+		"logging.aggregations":               {previous.Logging.Aggregations, current.Logging.Aggregations},
+		"logging.zeroResults.index":          {previous.Logging.ZeroResults.Index, current.Logging.ZeroResults.Index},
+		"logging.zeroResults.topN":           {previous.Logging.ZeroResults.TopN, current.Logging.ZeroResults.TopN},
+		"logging.zeroResults.window":         {previous.Logging.ZeroResults.Window, current.Logging.ZeroResults.Window},
+		"logging.geo":                        {previous.Logging.Geo, current.Logging.Geo},
+		"logging.userAgent":                  {previous.Logging.UserAgent, current.Logging.UserAgent},
+		"logging.privacy":                    {previous.Logging.Privacy, current.Logging.Privacy},
+		"logging.filterDebug.index":          {previous.Logging.FilterDebug.Index, current.Logging.FilterDebug.Index},
+		"logging.filterDebug.sampleRate":     {previous.Logging.FilterDebug.SampleRate, current.Logging.FilterDebug.SampleRate},
+		"logging.indexBootstrap":             {previous.Logging.IndexBootstrap, current.Logging.IndexBootstrap},
+	}
+
+	for setting, values := range settings {
+		if !reflect.DeepEqual(values[0], values[1]) {
+			changes = append(changes, setting)
+		}
+	}
+
+	sort.Strings(changes)
+
+	return changes
+}
+
+func getModTime(name string) time.Time {
+	info, err := os.Stat(name)
+
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
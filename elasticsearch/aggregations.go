@@ -0,0 +1,128 @@
+package elasticsearch
+
+import (
+	"github.com/tidwall/gjson"
+	"strings"
+)
+
+type MetricAggregationData struct {
+	Name    string                  `json:"name"`
+	Type    string                  `json:"type"`
+	Field   string                  `json:"field"`
+	Buckets []AggregationBucketData `json:"buckets,omitempty"`
+}
+
+type AggregationBucketData struct {
+	Key      string `json:"key"`
+	DocCount int64  `json:"docCount"`
+}
+
+// The front-end does most of its faceted filtering through post_filter so the filters are not applied to the
+// aggregations, these are extracted in exactly the same way as the query section. A metric that is also in the
+// query section keeps the query's value
+func ExtractPostFilterMetrics(queryLine gjson.Result, metrics map[string]interface{}) {
+	postFilterMetrics := make(map[string]interface{})
+
+	ExtractQueryMetricsRecursive(queryLine.Get("post_filter"), postFilterMetrics)
+
+	for name, metric := range postFilterMetrics {
+		if _, exists := metrics[name]; !exists {
+			metrics[name] = metric
+		}
+	}
+}
+
+// Records which aggregations (facets) were requested, if topBuckets is above zero then the top buckets from the
+// response are included as well
+func ExtractAggregationMetrics(queryLine gjson.Result, queryResponse gjson.Result, topBuckets int, metrics map[string]interface{}) {
+	aggregations := make([]interface{}, 0)
+
+	extractAggregationsRecursive(GetAggregations(queryLine), queryResponse.Get("aggregations"), "", topBuckets, &aggregations)
+
+	if len(aggregations) > 0 {
+		metrics[MetricAggregations] = aggregations
+	}
+}
+
+func GetAggregations(query gjson.Result) gjson.Result {
+	if aggs := query.Get("aggs"); aggs.Exists() {
+		return aggs
+	}
+
+	return query.Get("aggregations")
+}
+
+func extractAggregationsRecursive(aggs gjson.Result, response gjson.Result, prefix string, topBuckets int, aggregations *[]interface{}) {
+	if !aggs.IsObject() {
+		return
+	}
+
+	aggs.ForEach(func(name, definition gjson.Result) bool {
+		aggregation := MetricAggregationData{
+			Name: prefix + name.Str,
+		}
+
+		definition.ForEach(func(aggType, body gjson.Result) bool {
+			switch aggType.Str {
+			case "aggs", "aggregations", "meta":
+				return true
+			}
+
+			aggregation.Type = aggType.Str
+			aggregation.Field = body.Get("field").String()
+
+			if aggregation.Field == "" {
+				aggregation.Field = body.Get("path").String()
+			}
+
+			return false
+		})
+
+		aggResponse := response.Get(EscapeJsonPath(name.Str))
+
+		if topBuckets > 0 && aggResponse.Exists() {
+			aggregation.Buckets = ExtractTopBuckets(aggResponse.Get("buckets"), topBuckets)
+		}
+
+		*aggregations = append(*aggregations, aggregation)
+
+		extractAggregationsRecursive(GetAggregations(definition), aggResponse, aggregation.Name+".", topBuckets, aggregations)
+
+		return true
+	})
+}
+
+func ExtractTopBuckets(buckets gjson.Result, limit int) []AggregationBucketData {
+	var results []AggregationBucketData
+
+	add := func(key string, bucket gjson.Result) bool {
+		if keyAsString := bucket.Get("key_as_string"); keyAsString.Exists() {
+			key = keyAsString.String()
+		}
+
+		results = append(results, AggregationBucketData{
+			Key:      key,
+			DocCount: bucket.Get("doc_count").Int(),
+		})
+
+		return len(results) < limit
+	}
+
+	// Keyed aggregations (eg. range with keyed: true) return an object rather than an array
+	buckets.ForEach(func(key, bucket gjson.Result) bool {
+		if buckets.IsArray() {
+			return add(bucket.Get("key").String(), bucket)
+		}
+
+		return add(key.Str, bucket)
+	})
+
+	return results
+}
+
+// Aggregation names are user defined and may contain characters gjson treats as path syntax
+func EscapeJsonPath(key string) string {
+	replacer := strings.NewReplacer(".", `\.`, "*", `\*`, "?", `\?`, "|", `\|`, "#", `\#`, "@", `\@`)
+
+	return replacer.Replace(key)
+}
@@ -0,0 +1,79 @@
+package elasticsearch
+
+import (
+	"github.com/tidwall/gjson"
+	"testing"
+)
+
+func TestExtractPostFilterMetrics(t *testing.T) {
+	queryLine := gjson.Parse(`{"query":{"match_all":{}},"post_filter":{"bool":{"must":[{"range":{"listing.bedrooms":{"gte":2,"lte":3}}}]}}}`)
+	metrics := make(map[string]interface{})
+
+	ExtractPostFilterMetrics(queryLine, metrics)
+
+	if _, exists := metrics[MetricBedrooms]; !exists {
+		t.Error("Expected bedrooms metric from post_filter")
+	}
+
+	t.Run("query metrics are not replaced", func(t *testing.T) {
+		queryBedrooms := MetricRangeData{Minimum: 4, Maximum: 5}
+		metrics := map[string]interface{}{MetricBedrooms: queryBedrooms}
+
+		ExtractPostFilterMetrics(queryLine, metrics)
+
+		if metrics[MetricBedrooms] != queryBedrooms {
+			t.Errorf("expected the query bedrooms to be kept, got %+v", metrics[MetricBedrooms])
+		}
+	})
+}
+
+func TestExtractAggregationMetrics(t *testing.T) {
+	queryLine := gjson.Parse(`{"query":{"match_all":{}},"aggs":{"bedrooms":{"terms":{"field":"listing.bedrooms"}},"features":{"nested":{"path":"features"},"aggs":{"types":{"terms":{"field":"features.type.keyword"}}}}}}`)
+	response := gjson.Parse(`{"aggregations":{"bedrooms":{"buckets":[{"key":2,"doc_count":10},{"key":3,"doc_count":6},{"key":4,"doc_count":1}]},"features":{"doc_count":30,"types":{"buckets":[{"key":"GENERAL_PARKING","doc_count":12}]}}}}`)
+
+	t.Run("requested aggregations are recorded without buckets", func(t *testing.T) {
+		metrics := make(map[string]interface{})
+		ExtractAggregationMetrics(queryLine, response, 0, metrics)
+
+		aggregations := metrics[MetricAggregations].([]interface{})
+
+		if len(aggregations) != 3 {
+			t.Fatalf("expected 3 aggregations, got %d", len(aggregations))
+		}
+
+		first := aggregations[0].(MetricAggregationData)
+		if first.Name != "bedrooms" || first.Type != "terms" || first.Field != "listing.bedrooms" || len(first.Buckets) != 0 {
+			t.Errorf("unexpected aggregation: %+v", first)
+		}
+
+		nested := aggregations[2].(MetricAggregationData)
+		if nested.Name != "features.types" || nested.Field != "features.type.keyword" {
+			t.Errorf("unexpected nested aggregation: %+v", nested)
+		}
+	})
+
+	t.Run("top buckets are limited", func(t *testing.T) {
+		metrics := make(map[string]interface{})
+		ExtractAggregationMetrics(queryLine, response, 2, metrics)
+
+		aggregations := metrics[MetricAggregations].([]interface{})
+		buckets := aggregations[0].(MetricAggregationData).Buckets
+
+		if len(buckets) != 2 || buckets[0].Key != "2" || buckets[0].DocCount != 10 {
+			t.Errorf("unexpected buckets: %+v", buckets)
+		}
+
+		if len(aggregations[2].(MetricAggregationData).Buckets) != 1 {
+			t.Error("Expected nested aggregation buckets")
+		}
+	})
+
+	t.Run("no aggregations adds no metric", func(t *testing.T) {
+		metrics := make(map[string]interface{})
+		ExtractAggregationMetrics(gjson.Parse(`{"query":{}}`), response, 2, metrics)
+
+		if len(metrics) != 0 {
+			t.Fail()
+		}
+	})
+}
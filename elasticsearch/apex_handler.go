@@ -8,8 +8,8 @@ package elasticsearch
 import (
 	"bytes"
 	"context"
+	"elasticsearch-proxy/event"
 	"elasticsearch-proxy/util"
-	"encoding/json"
 	"fmt"
 	"github.com/apex/log"
 	"github.com/elastic/go-elasticsearch/v7"
@@ -21,7 +21,7 @@ import (
 // Config for handler.
 type ApexHandlerConfig struct {
 	BufferSize int                  // BufferSize is the number of logs to buffer before flush (default: 100)
-	IndexName  string               // Name for index
+	IndexName  string               // Name for index, alias or date based index name (eg. "queries-{yyyy.MM.dd}")
 	Client     elasticsearch.Client // Client for ES
 }
 
@@ -46,13 +46,16 @@ func (b *Batch) Flush() error {
 	var data bytes.Buffer
 
 	for _, logLine := range b.Logs {
-		jsonStr, err := json.Marshal(logLine)
+		jsonStr, err := event.Marshal(&logLine)
 
 		if err != nil {
 			log.Error("Failed to marshal log entry")
+
+			continue
 		}
 
-		data.WriteString(fmt.Sprintf(`{"index":{"_index":"%s"}}`, b.IndexName))
+		// Date based index names are evaluated per entry so a batch can span several indices
+		data.WriteString(fmt.Sprintf(`{"index":{"_index":"%s"}}`, FormatIndexName(b.IndexName, logLine.Timestamp)))
 		data.WriteByte('\n')
 
 		data.Write(jsonStr)
@@ -61,7 +64,6 @@ func (b *Batch) Flush() error {
 
 	req := esapi.BulkRequest{
 		Pretty: true,
-		Index:  b.IndexName,
 		Body:   &data,
 	}
 
@@ -100,6 +102,18 @@ func NewElasticsearchHandler(config *ApexHandlerConfig) *Handler {
 	}
 }
 
+// SetBufferSize changes the flush size, applied from the next log onwards
+func (h *Handler) SetBufferSize(size int) {
+	h.Mutex.Lock()
+	defer h.Mutex.Unlock()
+
+	if size == 0 {
+		size = 100
+	}
+
+	h.BufferSize = size
+}
+
 // HandleLog implements log.Handler.
 func (h *Handler) HandleLog(e *log.Entry) error {
 	h.Mutex.Lock()
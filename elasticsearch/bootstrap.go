@@ -0,0 +1,226 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"elasticsearch-proxy/config"
+	"elasticsearch-proxy/util"
+	"encoding/json"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// BootstrapIndices creates the lifecycle policy, the index templates and the initial indices behind write aliases
+// when they do not exist yet, anything that already exists is left alone so it can be managed by hand. A failing
+// queue does not stop the others from being bootstrapped, every failure is returned together
+func BootstrapIndices(client *elasticsearch.Client, cfg config.Config) error {
+	bootstrap := cfg.Logging.IndexBootstrap
+	errors := make([]string, 0)
+
+	if bootstrap.LifecyclePolicy != "" {
+		if err := ensureLifecyclePolicy(client, bootstrap); err != nil {
+			errors = append(errors, fmt.Sprintf("lifecycle policy %s: %v", bootstrap.LifecyclePolicy, err))
+		}
+	}
+
+	// The zero results and filter debug indices have no mappingPath, they are left to the dynamic mapping
+	queues := []config.ElasticsearchIndexQueueConfig{
+		cfg.Logging.ElasticsearchQueries,
+		cfg.Logging.LycanPriceRequests,
+		cfg.Logging.PropertyViews,
+	}
+
+	for _, queue := range queues {
+		if queue.Index == "" || queue.MappingPath == "" || !queue.WritesToElasticsearch() {
+			continue
+		}
+
+		if err := bootstrapQueue(client, queue, bootstrap); err != nil {
+			errors = append(errors, fmt.Sprintf("%s: %v", queue.Index, err))
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("%d bootstrap step(s) failed: %s", len(errors), strings.Join(errors, "; "))
+	}
+
+	return nil
+}
+
+func bootstrapQueue(client *elasticsearch.Client, queue config.ElasticsearchIndexQueueConfig, bootstrap config.IndexBootstrapConfig) error {
+	if err := ensureIndexTemplate(client, queue, bootstrap); err != nil {
+		return err
+	}
+
+	if queue.WriteAlias {
+		return ensureWriteAlias(client, queue.Index)
+	}
+
+	return nil
+}
+
+// BuildLifecyclePolicy rolls the write index over in the hot phase and deletes indices after the retention period
+func BuildLifecyclePolicy(bootstrap config.IndexBootstrapConfig) map[string]interface{} {
+	phases := map[string]interface{}{}
+
+	rollover := map[string]interface{}{}
+	if bootstrap.RolloverMaxAge != "" {
+		rollover["max_age"] = bootstrap.RolloverMaxAge
+	}
+	if bootstrap.RolloverMaxSize != "" {
+		rollover["max_size"] = bootstrap.RolloverMaxSize
+	}
+
+	// Date based index names are not rolled over, they only need the delete phase
+	if len(rollover) > 0 {
+		phases["hot"] = map[string]interface{}{
+			"actions": map[string]interface{}{"rollover": rollover},
+		}
+	}
+
+	if bootstrap.DeleteAfter != "" {
+		phases["delete"] = map[string]interface{}{
+			"min_age": bootstrap.DeleteAfter,
+			"actions": map[string]interface{}{"delete": map[string]interface{}{}},
+		}
+	}
+
+	return map[string]interface{}{
+		"policy": map[string]interface{}{"phases": phases},
+	}
+}
+
+// BuildIndexTemplate uses the mappings exported to the mapping JSON files, eg. elasticsearch_logging.mapping.json
+func BuildIndexTemplate(queue config.ElasticsearchIndexQueueConfig, bootstrap config.IndexBootstrapConfig, mappingJson []byte) (map[string]interface{}, error) {
+	var mapping map[string]interface{}
+
+	if err := json.Unmarshal(mappingJson, &mapping); err != nil {
+		return nil, fmt.Errorf("invalid mapping %s: %v", queue.MappingPath, err)
+	}
+
+	mappings, ok := mapping["mapping"]
+	if !ok {
+		mappings, ok = mapping["mappings"]
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("no mapping found in %s", queue.MappingPath)
+	}
+
+	settings := map[string]interface{}{}
+
+	if bootstrap.LifecyclePolicy != "" {
+		settings["index.lifecycle.name"] = bootstrap.LifecyclePolicy
+
+		if queue.WriteAlias {
+			settings["index.lifecycle.rollover_alias"] = queue.Index
+		}
+	}
+
+	return map[string]interface{}{
+		"index_patterns": []string{IndexPattern(queue.Index, queue.WriteAlias)},
+		"settings":       settings,
+		"mappings":       mappings,
+	}, nil
+}
+
+func ensureLifecyclePolicy(client *elasticsearch.Client, bootstrap config.IndexBootstrapConfig) error {
+	exists, err := checkExists(client, esapi.ILMGetLifecycleRequest{Policy: bootstrap.LifecyclePolicy})
+
+	if err != nil || exists {
+		return err
+	}
+
+	body, _ := json.Marshal(BuildLifecyclePolicy(bootstrap))
+
+	log.WithField("policy", bootstrap.LifecyclePolicy).Info(util.LogMsg("Creating index lifecycle policy"))
+
+	return checkAcknowledged(client, esapi.ILMPutLifecycleRequest{Policy: bootstrap.LifecyclePolicy, Body: bytes.NewReader(body)})
+}
+
+func ensureIndexTemplate(client *elasticsearch.Client, queue config.ElasticsearchIndexQueueConfig, bootstrap config.IndexBootstrapConfig) error {
+	name := IndexTemplateName(queue.Index)
+
+	exists, err := checkExists(client, esapi.IndicesExistsTemplateRequest{Name: []string{name}})
+
+	if err != nil || exists {
+		return err
+	}
+
+	mappingJson, err := ioutil.ReadFile(queue.MappingPath)
+
+	if err != nil {
+		return err
+	}
+
+	template, err := BuildIndexTemplate(queue, bootstrap, mappingJson)
+
+	if err != nil {
+		return err
+	}
+
+	body, _ := json.Marshal(template)
+
+	log.WithField("template", name).Info(util.LogMsg("Creating index template"))
+
+	return checkAcknowledged(client, esapi.IndicesPutTemplateRequest{Name: name, Body: bytes.NewReader(body)})
+}
+
+// The first index is created by hand, after that ILM rolls the alias over to a new index
+func ensureWriteAlias(client *elasticsearch.Client, alias string) error {
+	exists, err := checkExists(client, esapi.IndicesExistsAliasRequest{Name: []string{alias}})
+
+	if err != nil || exists {
+		return err
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"aliases": map[string]interface{}{
+			alias: map[string]interface{}{"is_write_index": true},
+		},
+	})
+
+	log.WithField("alias", alias).Info(util.LogMsg("Creating initial index for write alias"))
+
+	return checkAcknowledged(client, esapi.IndicesCreateRequest{Index: alias + "-000001", Body: bytes.NewReader(body)})
+}
+
+func checkExists(client *elasticsearch.Client, req esapi.Request) (bool, error) {
+	res, err := req.Do(context.Background(), client.Transport)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+
+	return false, fmt.Errorf("unexpected response checking for existing resource: %s", res.String())
+}
+
+func checkAcknowledged(client *elasticsearch.Client, req esapi.Request) error {
+	res, err := req.Do(context.Background(), client.Transport)
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("bootstrap request failed: %s", res.String())
+	}
+
+	return nil
+}
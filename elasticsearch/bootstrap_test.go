@@ -0,0 +1,61 @@
+package elasticsearch
+
+import (
+	"elasticsearch-proxy/config"
+	"github.com/elastic/go-elasticsearch/v7"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBootstrapIndicesContinuesAfterAFailure(t *testing.T) {
+	var mu sync.Mutex
+	created := make([]string, 0)
+
+	// Nothing exists yet, only the price requests template is rejected
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || r.Method == http.MethodGet {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if strings.Contains(r.URL.Path, "test-price-requests") {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid template"}`))
+			return
+		}
+
+		mu.Lock()
+		created = append(created, r.URL.Path)
+		mu.Unlock()
+
+		w.Write([]byte(`{"acknowledged":true}`))
+	}))
+	defer server.Close()
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Config{}
+	cfg.Logging.IndexBootstrap = config.IndexBootstrapConfig{Enabled: true}
+	cfg.Logging.LycanPriceRequests = config.ElasticsearchIndexQueueConfig{Index: "test-price-requests", MappingPath: "../price_requests_logging.mapping.json"}
+	cfg.Logging.PropertyViews = config.ElasticsearchIndexQueueConfig{Index: "test-property-views", WriteAlias: true, MappingPath: "../elasticsearch_logging.mapping.json"}
+	cfg.Logging.ElasticsearchQueries = config.ElasticsearchIndexQueueConfig{Index: "test-es-queries", MappingPath: "does-not-exist.mapping.json"}
+
+	err = BootstrapIndices(client, cfg)
+
+	if err == nil || !strings.Contains(err.Error(), "test-price-requests") || !strings.Contains(err.Error(), "test-es-queries") {
+		t.Errorf("expected both failing queues to be reported, got %v", err)
+	}
+
+	expected := []string{"/_template/test-property-views", "/test-property-views-000001"}
+
+	if strings.Join(created, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected the property views to still be bootstrapped with %v, got %v", expected, created)
+	}
+}
@@ -0,0 +1,237 @@
+package elasticsearch
+
+import (
+	"reflect"
+	"sort"
+)
+
+const DiffAdded = "added"
+const DiffRemoved = "removed"
+const DiffChanged = "changed"
+
+// A single difference between two metric maps, from and to are the metric values before and after. For changed
+// metrics of a known type the attributes that changed are listed (using their JSON names) and feature searches
+// also list the individual items that were added or removed
+type MetricDiff struct {
+	Metric       string      `json:"metric"`
+	Type         string      `json:"type"`
+	From         interface{} `json:"from,omitempty"`
+	To           interface{} `json:"to,omitempty"`
+	Fields       []string    `json:"fields,omitempty"`
+	ItemsAdded   []string    `json:"itemsAdded,omitempty"`
+	ItemsRemoved []string    `json:"itemsRemoved,omitempty"`
+}
+
+// These describe the response rather than what was searched for, so they are not part of a refinement
+var ignoredDiffMetrics = map[string]bool{
+	MetricResponse:     true,
+	MetricResults:      true,
+	MetricAggregations: true,
+	MetricPropertyView: true,
+}
+
+// DiffMetrics compares the metrics of two queries (as produced by ExtractQueryMetrics), the differences are
+// sorted by metric name
+func DiffMetrics(a map[string]interface{}, b map[string]interface{}) []MetricDiff {
+	names := make([]string, 0, len(a)+len(b))
+
+	for name := range a {
+		names = append(names, name)
+	}
+
+	for name := range b {
+		if _, exists := a[name]; !exists {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	diffs := make([]MetricDiff, 0)
+
+	for _, name := range names {
+		if ignoredDiffMetrics[name] {
+			continue
+		}
+
+		from, inA := a[name]
+		to, inB := b[name]
+
+		switch {
+		case !inA:
+			diffs = append(diffs, MetricDiff{Metric: name, Type: DiffAdded, To: to})
+		case !inB:
+			diffs = append(diffs, MetricDiff{Metric: name, Type: DiffRemoved, From: from})
+		default:
+			if diff, changed := DiffMetric(name, from, to); changed {
+				diffs = append(diffs, diff)
+			}
+		}
+	}
+
+	return diffs
+}
+
+// DiffMetric compares two values of the same metric, unknown types are compared as a whole
+func DiffMetric(name string, from interface{}, to interface{}) (MetricDiff, bool) {
+	diff := MetricDiff{Metric: name, Type: DiffChanged, From: from, To: to}
+
+	if a, ok := featureGroups(from); ok {
+		if b, ok := featureGroups(to); ok {
+			// The order the features were ticked in does not matter
+			itemsA, itemsB := featureItems(a), featureItems(b)
+
+			diff.ItemsAdded = missingItems(itemsB, itemsA)
+			diff.ItemsRemoved = missingItems(itemsA, itemsB)
+			diff.Fields = changedFields(map[string]bool{
+				"groups":     len(a) != len(b),
+				"searchType": !reflect.DeepEqual(featureSearchTypes(a), featureSearchTypes(b)),
+				"items":      len(diff.ItemsAdded) > 0 || len(diff.ItemsRemoved) > 0,
+			})
+
+			return diff, len(diff.Fields) > 0
+		}
+	}
+
+	switch a := from.(type) {
+	case MetricRangeData:
+		if b, ok := to.(MetricRangeData); ok {
+			diff.Fields = changedFields(map[string]bool{
+				"minimum": a.Minimum != b.Minimum,
+				"maximum": a.Maximum != b.Maximum,
+			})
+
+			return diff, len(diff.Fields) > 0
+		}
+	case MetricDateRangeData:
+		if b, ok := to.(MetricDateRangeData); ok {
+			diff.Fields = changedFields(map[string]bool{
+				"arrivalDate":   a.ArrivalDate != b.ArrivalDate,
+				"departureDate": a.DepartureDate != b.DepartureDate,
+				"nights":        a.Nights != b.Nights,
+			})
+
+			return diff, len(diff.Fields) > 0
+		}
+	case MetricLocationData:
+		// The geo point is a copy of the coordinates so it is not compared separately
+		if b, ok := to.(MetricLocationData); ok {
+			diff.Fields = changedFields(map[string]bool{
+				"distance":  a.Distance != b.Distance,
+				"latitude":  a.Latitude != b.Latitude,
+				"longitude": a.Longitude != b.Longitude,
+			})
+
+			return diff, len(diff.Fields) > 0
+		}
+	case MetricKeywordSearchData:
+		if b, ok := to.(MetricKeywordSearchData); ok {
+			diff.Fields = changedFields(map[string]bool{"searchTerm": a.Term != b.Term})
+
+			return diff, len(diff.Fields) > 0
+		}
+	case MetricAgencyData:
+		if b, ok := to.(MetricAgencyData); ok {
+			diff.Fields = changedFields(map[string]bool{"companyName": a.CompanyName != b.CompanyName})
+
+			return diff, len(diff.Fields) > 0
+		}
+	}
+
+	return diff, !reflect.DeepEqual(from, to)
+}
+
+// ExtractQueryMetrics stores each nested features query as its own group in a []interface{}
+func featureGroups(value interface{}) ([]MetricFeaturesData, bool) {
+	switch features := value.(type) {
+	case MetricFeaturesData:
+		return []MetricFeaturesData{features}, true
+	case []interface{}:
+		groups := make([]MetricFeaturesData, 0, len(features))
+
+		for _, feature := range features {
+			group, ok := feature.(MetricFeaturesData)
+
+			if !ok {
+				return nil, false
+			}
+
+			groups = append(groups, group)
+		}
+
+		return groups, true
+	}
+
+	return nil, false
+}
+
+func featureItems(groups []MetricFeaturesData) []string {
+	items := make([]string, 0)
+
+	for _, group := range groups {
+		items = append(items, group.Items...)
+	}
+
+	return items
+}
+
+// The distinct search types, a group being added or removed is reported as a change of groups instead
+func featureSearchTypes(groups []MetricFeaturesData) []string {
+	seen := make(map[string]bool, len(groups))
+	searchTypes := make([]string, 0, len(groups))
+
+	for _, group := range groups {
+		if !seen[group.SearchType] {
+			seen[group.SearchType] = true
+			searchTypes = append(searchTypes, group.SearchType)
+		}
+	}
+
+	sort.Strings(searchTypes)
+
+	return searchTypes
+}
+
+func changedFields(comparisons map[string]bool) []string {
+	fields := make([]string, 0)
+
+	for field, changed := range comparisons {
+		if changed {
+			fields = append(fields, field)
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	sort.Strings(fields)
+
+	return fields
+}
+
+// The items in a that are not in b, sorted
+func missingItems(a []string, b []string) []string {
+	present := make(map[string]bool, len(b))
+
+	for _, item := range b {
+		present[item] = true
+	}
+
+	missing := make([]string, 0)
+
+	for _, item := range a {
+		if !present[item] {
+			present[item] = true
+			missing = append(missing, item)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	sort.Strings(missing)
+
+	return missing
+}
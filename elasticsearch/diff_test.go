@@ -0,0 +1,220 @@
+package elasticsearch
+
+import (
+	"github.com/tidwall/gjson"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffMetrics(t *testing.T) {
+	bedrooms := MetricRangeData{Minimum: 2, Maximum: 3}
+	june := MetricDateRangeData{ArrivalDate: "2020-06-01", DepartureDate: "2020-06-08", Nights: 7}
+	hotTub := MetricFeaturesData{SearchType: "and", Items: []string{"hot-tub", "wifi"}}
+	cornwall := MetricLocationData{Distance: "10km", Latitude: 50.26, Longitude: -5.05, GeoPoint: GeoPointData{Lat: 50.26, Lon: -5.05}}
+	response := MetricResponseData{QueryTimeMs: 12, ResultCount: 40}
+
+	// Features as they are actually extracted, one group per nested query
+	petsAndParking := extractFeatures(`[["GENERAL_PET_FRIENDLY","SUITABILITY_PETS_ALLOWED"],["GENERAL_PARKING","GENERAL_SHARED_PRIVATE_PARKING"]]`)
+	petsAndGarden := extractFeatures(`[["SUITABILITY_PETS_ALLOWED","GENERAL_PET_FRIENDLY"],["OUTDOOR_GARDEN"]]`)
+	petsOnly := extractFeatures(`[["GENERAL_PET_FRIENDLY","SUITABILITY_PETS_ALLOWED"]]`)
+
+	tests := []struct {
+		name     string
+		a        map[string]interface{}
+		b        map[string]interface{}
+		expected []MetricDiff
+	}{
+		{"both empty", map[string]interface{}{}, map[string]interface{}{}, []MetricDiff{}},
+		{"nil maps", nil, nil, []MetricDiff{}},
+		{
+			"identical",
+			map[string]interface{}{MetricBedrooms: bedrooms, MetricDateRange: june, MetricFeatures: hotTub, MetricLocation: cornwall},
+			map[string]interface{}{MetricBedrooms: bedrooms, MetricDateRange: june, MetricFeatures: hotTub, MetricLocation: cornwall},
+			[]MetricDiff{},
+		},
+		{
+			"response metrics are ignored",
+			map[string]interface{}{MetricResponse: response, MetricResults: []string{"a"}, MetricAggregations: map[string]int{"a": 1}},
+			map[string]interface{}{MetricResponse: MetricResponseData{QueryTimeMs: 3}, MetricPropertyView: "abc-123"},
+			[]MetricDiff{},
+		},
+		{
+			"range added",
+			map[string]interface{}{},
+			map[string]interface{}{MetricBedrooms: bedrooms},
+			[]MetricDiff{{Metric: MetricBedrooms, Type: DiffAdded, To: bedrooms}},
+		},
+		{
+			"date range removed",
+			map[string]interface{}{MetricDateRange: june},
+			map[string]interface{}{},
+			[]MetricDiff{{Metric: MetricDateRange, Type: DiffRemoved, From: june}},
+		},
+		{
+			"range minimum changed",
+			map[string]interface{}{MetricBedrooms: bedrooms},
+			map[string]interface{}{MetricBedrooms: MetricRangeData{Minimum: 3, Maximum: 3}},
+			[]MetricDiff{{Metric: MetricBedrooms, Type: DiffChanged, From: bedrooms, To: MetricRangeData{Minimum: 3, Maximum: 3}, Fields: []string{"minimum"}}},
+		},
+		{
+			"range maximum changed",
+			map[string]interface{}{MetricNightlyLowPrice: MetricRangeData{Minimum: 0, Maximum: 100}},
+			map[string]interface{}{MetricNightlyLowPrice: MetricRangeData{Minimum: 0, Maximum: 9999}},
+			[]MetricDiff{{Metric: MetricNightlyLowPrice, Type: DiffChanged, From: MetricRangeData{Minimum: 0, Maximum: 100}, To: MetricRangeData{Minimum: 0, Maximum: 9999}, Fields: []string{"maximum"}}},
+		},
+		{
+			"range both bounds changed",
+			map[string]interface{}{MetricGuests: MetricRangeData{Minimum: 2, Maximum: 2}},
+			map[string]interface{}{MetricGuests: MetricRangeData{Minimum: 4, Maximum: 6}},
+			[]MetricDiff{{Metric: MetricGuests, Type: DiffChanged, From: MetricRangeData{Minimum: 2, Maximum: 2}, To: MetricRangeData{Minimum: 4, Maximum: 6}, Fields: []string{"maximum", "minimum"}}},
+		},
+		{
+			"dates shifted keeping the nights",
+			map[string]interface{}{MetricDateRange: june},
+			map[string]interface{}{MetricDateRange: MetricDateRangeData{ArrivalDate: "2020-06-02", DepartureDate: "2020-06-09", Nights: 7}},
+			[]MetricDiff{{Metric: MetricDateRange, Type: DiffChanged, From: june, To: MetricDateRangeData{ArrivalDate: "2020-06-02", DepartureDate: "2020-06-09", Nights: 7}, Fields: []string{"arrivalDate", "departureDate"}}},
+		},
+		{
+			"stay extended",
+			map[string]interface{}{MetricDateRange: june},
+			map[string]interface{}{MetricDateRange: MetricDateRangeData{ArrivalDate: "2020-06-01", DepartureDate: "2020-06-15", Nights: 14}},
+			[]MetricDiff{{Metric: MetricDateRange, Type: DiffChanged, From: june, To: MetricDateRangeData{ArrivalDate: "2020-06-01", DepartureDate: "2020-06-15", Nights: 14}, Fields: []string{"departureDate", "nights"}}},
+		},
+		{
+			"feature added",
+			map[string]interface{}{MetricFeatures: hotTub},
+			map[string]interface{}{MetricFeatures: MetricFeaturesData{SearchType: "and", Items: []string{"hot-tub", "wifi", "parking"}}},
+			[]MetricDiff{{Metric: MetricFeatures, Type: DiffChanged, From: hotTub, To: MetricFeaturesData{SearchType: "and", Items: []string{"hot-tub", "wifi", "parking"}}, Fields: []string{"items"}, ItemsAdded: []string{"parking"}}},
+		},
+		{
+			"feature swapped",
+			map[string]interface{}{MetricFeatures: hotTub},
+			map[string]interface{}{MetricFeatures: MetricFeaturesData{SearchType: "and", Items: []string{"wifi", "pool", "garden"}}},
+			[]MetricDiff{{Metric: MetricFeatures, Type: DiffChanged, From: hotTub, To: MetricFeaturesData{SearchType: "and", Items: []string{"wifi", "pool", "garden"}}, Fields: []string{"items"}, ItemsAdded: []string{"garden", "pool"}, ItemsRemoved: []string{"hot-tub"}}},
+		},
+		{
+			"features reordered",
+			map[string]interface{}{MetricFeatures: hotTub},
+			map[string]interface{}{MetricFeatures: MetricFeaturesData{SearchType: "and", Items: []string{"wifi", "hot-tub"}}},
+			[]MetricDiff{},
+		},
+		{
+			"duplicate feature",
+			map[string]interface{}{MetricFeatures: hotTub},
+			map[string]interface{}{MetricFeatures: MetricFeaturesData{SearchType: "and", Items: []string{"hot-tub", "wifi", "wifi"}}},
+			[]MetricDiff{},
+		},
+		{
+			"feature search type changed",
+			map[string]interface{}{MetricFeatures: hotTub},
+			map[string]interface{}{MetricFeatures: MetricFeaturesData{SearchType: "or", Items: []string{"hot-tub", "wifi"}}},
+			[]MetricDiff{{Metric: MetricFeatures, Type: DiffChanged, From: hotTub, To: MetricFeaturesData{SearchType: "or", Items: []string{"hot-tub", "wifi"}}, Fields: []string{"searchType"}}},
+		},
+		{
+			"extracted features unchanged",
+			map[string]interface{}{MetricFeatures: petsAndParking},
+			map[string]interface{}{MetricFeatures: extractFeatures(`[["GENERAL_PET_FRIENDLY","SUITABILITY_PETS_ALLOWED"],["GENERAL_PARKING","GENERAL_SHARED_PRIVATE_PARKING"]]`)},
+			[]MetricDiff{},
+		},
+		{
+			"extracted feature group replaced",
+			map[string]interface{}{MetricFeatures: petsAndParking},
+			map[string]interface{}{MetricFeatures: petsAndGarden},
+			[]MetricDiff{{Metric: MetricFeatures, Type: DiffChanged, From: petsAndParking, To: petsAndGarden, Fields: []string{"items"}, ItemsAdded: []string{"OUTDOOR_GARDEN"}, ItemsRemoved: []string{"GENERAL_PARKING", "GENERAL_SHARED_PRIVATE_PARKING"}}},
+		},
+		{
+			"extracted feature group removed",
+			map[string]interface{}{MetricFeatures: petsAndParking},
+			map[string]interface{}{MetricFeatures: petsOnly},
+			[]MetricDiff{{Metric: MetricFeatures, Type: DiffChanged, From: petsAndParking, To: petsOnly, Fields: []string{"groups", "items"}, ItemsRemoved: []string{"GENERAL_PARKING", "GENERAL_SHARED_PRIVATE_PARKING"}}},
+		},
+		{
+			"location distance widened",
+			map[string]interface{}{MetricLocation: cornwall},
+			map[string]interface{}{MetricLocation: MetricLocationData{Distance: "25km", Latitude: 50.26, Longitude: -5.05, GeoPoint: GeoPointData{Lat: 50.26, Lon: -5.05}}},
+			[]MetricDiff{{Metric: MetricLocation, Type: DiffChanged, From: cornwall, To: MetricLocationData{Distance: "25km", Latitude: 50.26, Longitude: -5.05, GeoPoint: GeoPointData{Lat: 50.26, Lon: -5.05}}, Fields: []string{"distance"}}},
+		},
+		{
+			"location moved",
+			map[string]interface{}{MetricLocation: cornwall},
+			map[string]interface{}{MetricLocation: MetricLocationData{Distance: "10km", Latitude: 50.15, Longitude: -5.05, GeoPoint: GeoPointData{Lat: 50.15, Lon: -5.05}}},
+			[]MetricDiff{{Metric: MetricLocation, Type: DiffChanged, From: cornwall, To: MetricLocationData{Distance: "10km", Latitude: 50.15, Longitude: -5.05, GeoPoint: GeoPointData{Lat: 50.15, Lon: -5.05}}, Fields: []string{"latitude"}}},
+		},
+		{
+			"location geo point only",
+			map[string]interface{}{MetricLocation: cornwall},
+			map[string]interface{}{MetricLocation: MetricLocationData{Distance: "10km", Latitude: 50.26, Longitude: -5.05}},
+			[]MetricDiff{},
+		},
+		{
+			"search term changed",
+			map[string]interface{}{MetricPropertySearch: MetricKeywordSearchData{Term: "cottage"}},
+			map[string]interface{}{MetricPropertySearch: MetricKeywordSearchData{Term: "sea view cottage"}},
+			[]MetricDiff{{Metric: MetricPropertySearch, Type: DiffChanged, From: MetricKeywordSearchData{Term: "cottage"}, To: MetricKeywordSearchData{Term: "sea view cottage"}, Fields: []string{"searchTerm"}}},
+		},
+		{
+			"agency changed",
+			map[string]interface{}{MetricAgency: MetricAgencyData{CompanyName: "dormoa"}},
+			map[string]interface{}{MetricAgency: MetricAgencyData{CompanyName: "other"}},
+			[]MetricDiff{{Metric: MetricAgency, Type: DiffChanged, From: MetricAgencyData{CompanyName: "dormoa"}, To: MetricAgencyData{CompanyName: "other"}, Fields: []string{"companyName"}}},
+		},
+		{
+			"unknown metric changed",
+			map[string]interface{}{MetricUnknown: map[string]interface{}{"a": 1}},
+			map[string]interface{}{MetricUnknown: map[string]interface{}{"a": 2}},
+			[]MetricDiff{{Metric: MetricUnknown, Type: DiffChanged, From: map[string]interface{}{"a": 1}, To: map[string]interface{}{"a": 2}}},
+		},
+		{
+			"unknown metric unchanged",
+			map[string]interface{}{MetricUnknown: map[string]interface{}{"a": 1}},
+			map[string]interface{}{MetricUnknown: map[string]interface{}{"a": 1}},
+			[]MetricDiff{},
+		},
+		{
+			"metric type changed",
+			map[string]interface{}{MetricBedrooms: bedrooms},
+			map[string]interface{}{MetricBedrooms: "2"},
+			[]MetricDiff{{Metric: MetricBedrooms, Type: DiffChanged, From: bedrooms, To: "2"}},
+		},
+		{
+			"several changes sorted by metric",
+			map[string]interface{}{MetricGuests: MetricRangeData{Minimum: 2}, MetricDateRange: june, MetricResponse: response},
+			map[string]interface{}{MetricGuests: MetricRangeData{Minimum: 4}, MetricBedrooms: bedrooms, MetricResponse: MetricResponseData{}},
+			[]MetricDiff{
+				{Metric: MetricBedrooms, Type: DiffAdded, To: bedrooms},
+				{Metric: MetricDateRange, Type: DiffRemoved, From: june},
+				{Metric: MetricGuests, Type: DiffChanged, From: MetricRangeData{Minimum: 2}, To: MetricRangeData{Minimum: 4}, Fields: []string{"minimum"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			diffs := DiffMetrics(test.a, test.b)
+
+			if !reflect.DeepEqual(diffs, test.expected) {
+				t.Errorf("expected\n%+v\ngot\n%+v", test.expected, diffs)
+			}
+		})
+	}
+}
+
+// Builds a query with a nested features query per group and returns the extracted features metric
+func extractFeatures(groups string) interface{} {
+	nested := make([]string, 0)
+
+	for _, group := range gjson.Parse(groups).Array() {
+		terms := make([]string, 0)
+
+		for _, item := range group.Array() {
+			terms = append(terms, `{"term":{"features.type.keyword":"`+item.String()+`"}}`)
+		}
+
+		nested = append(nested, `{"nested":{"path":"features","query":{"bool":{"should":[`+strings.Join(terms, ",")+`]}}}}`)
+	}
+
+	query := gjson.Parse(`{"bool":{"must":[` + strings.Join(nested, ",") + `]}}`)
+
+	return ExtractQueryMetrics(query, gjson.Result{})[MetricFeatures]
+}
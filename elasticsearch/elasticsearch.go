@@ -2,21 +2,42 @@ package elasticsearch
 
 import (
 	"elasticsearch-proxy/config"
+	"elasticsearch-proxy/sink"
 	"elasticsearch-proxy/util"
 	"github.com/apex/log"
 	"github.com/elastic/go-elasticsearch/v7"
+	"net/http"
 )
 
 var EsQueryLogger *log.Logger
 var LycanPriceRequestLogger *log.Logger
+var PropertyViewLogger *log.Logger
+var ZeroResultLogger *log.Logger
+var FilterDebugLogger *log.Logger
 
 func ConfigureLoggers(cfg config.Config) {
+	credentials := cfg.Logging.EsCredentials
+
+	tlsConfig, err := credentials.Tls.BuildTlsConfig()
+
+	if err != nil {
+		panic("Could not configure TLS for the logging cluster: " + err.Error())
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	// An API key takes precedence over the username and password
 	esCfg := elasticsearch.Config{
-		Username: cfg.Logging.EsCredentials.Username,
-		Password: cfg.Logging.EsCredentials.Password,
-		Addresses: []string{
-			cfg.Logging.EsCredentials.GetUrl(),
-		},
+		Username:  credentials.Username,
+		Password:  credentials.Password,
+		APIKey:    credentials.ApiKey,
+		CloudID:   credentials.CloudId,
+		Transport: transport,
+	}
+
+	if credentials.CloudId == "" {
+		esCfg.Addresses = []string{credentials.GetUrl()}
 	}
 
 	client, err := elasticsearch.NewClient(esCfg)
@@ -31,30 +52,111 @@ func ConfigureLoggers(cfg config.Config) {
 		panic("Could not configure ES client")
 	}
 
+	if cfg.Logging.IndexBootstrap.Enabled {
+		// Logging still works against existing indices, so a failure here is not fatal
+		if err := BootstrapIndices(client, cfg); err != nil {
+			log.WithField("error", err.Error()).Error(util.LogMsg("Could not bootstrap the logging indices"))
+		}
+	}
+
 	if EsQueryLogger == nil {
+		EsQueryLogger = newQueueLogger(cfg.Logging.ElasticsearchQueries, *client)
+	}
+
+	if LycanPriceRequestLogger == nil {
+		LycanPriceRequestLogger = newQueueLogger(cfg.Logging.LycanPriceRequests, *client)
+	}
+
+	// Property views are optional, without an index or sinks they are simply not logged
+	if PropertyViewLogger == nil && cfg.Logging.PropertyViews.Enabled() {
+		PropertyViewLogger = newQueueLogger(cfg.Logging.PropertyViews, *client)
+	}
+
+	if ZeroResultLogger == nil && cfg.Logging.ZeroResults.Index != "" {
 		handler := NewElasticsearchHandler(&ApexHandlerConfig{
-			BufferSize: cfg.Logging.ElasticsearchQueries.LogBufferSize,
-			IndexName:  cfg.Logging.ElasticsearchQueries.Index,
+			BufferSize: cfg.Logging.ZeroResults.LogBufferSize,
+			IndexName:  cfg.Logging.ZeroResults.Index,
 			Client:     *client,
 		})
 
-		EsQueryLogger = &log.Logger{
+		ZeroResultLogger = &log.Logger{
 			Handler: handler,
 			Level:   log.InfoLevel,
 		}
 	}
 
-	if LycanPriceRequestLogger == nil {
+	if FilterDebugLogger == nil && cfg.Logging.FilterDebug.Index != "" {
 		handler := NewElasticsearchHandler(&ApexHandlerConfig{
-			BufferSize: cfg.Logging.LycanPriceRequests.LogBufferSize,
-			IndexName:  cfg.Logging.LycanPriceRequests.Index,
+			BufferSize: cfg.Logging.FilterDebug.LogBufferSize,
+			IndexName:  cfg.Logging.FilterDebug.Index,
 			Client:     *client,
 		})
 
-		LycanPriceRequestLogger = &log.Logger{
+		FilterDebugLogger = &log.Logger{
 			Handler: handler,
 			Level:   log.InfoLevel,
 		}
 	}
 
+	config.OnReload(func(previous config.Config, current config.Config) {
+		SetLoggerBufferSize(EsQueryLogger, current.Logging.ElasticsearchQueries.LogBufferSize)
+		SetLoggerBufferSize(LycanPriceRequestLogger, current.Logging.LycanPriceRequests.LogBufferSize)
+		SetLoggerBufferSize(PropertyViewLogger, current.Logging.PropertyViews.LogBufferSize)
+		SetLoggerBufferSize(ZeroResultLogger, current.Logging.ZeroResults.LogBufferSize)
+		SetLoggerBufferSize(FilterDebugLogger, current.Logging.FilterDebug.LogBufferSize)
+	})
+}
+
+// The queue's entries go to its index unless other sinks are configured, in which case the index is optional
+func newQueueLogger(queue config.ElasticsearchIndexQueueConfig, client elasticsearch.Client) *log.Logger {
+	var bulkHandler sink.Sink
+
+	if queue.WritesToElasticsearch() {
+		bulkHandler = NewElasticsearchHandler(&ApexHandlerConfig{
+			BufferSize: queue.LogBufferSize,
+			IndexName:  queue.Index,
+			Client:     client,
+		})
+	}
+
+	handler, err := sink.New(queue.Sinks, bulkHandler)
+
+	if err != nil {
+		panic("Could not configure sinks: " + err.Error())
+	}
+
+	return &log.Logger{
+		Handler: handler,
+		Level:   log.InfoLevel,
+	}
+}
+
+// CloseSinks flushes the queue loggers' sinks, used on shutdown so batched entries are not lost
+func CloseSinks() {
+	for _, logger := range []*log.Logger{EsQueryLogger, LycanPriceRequestLogger, PropertyViewLogger} {
+		if logger == nil {
+			continue
+		}
+
+		if err := sink.Close(logger.Handler); err != nil {
+			log.WithField("error", err.Error()).Error(util.LogMsg("Could not close sinks"))
+		}
+	}
+}
+
+func SetLoggerBufferSize(logger *log.Logger, size int) {
+	if logger == nil {
+		return
+	}
+
+	switch handler := logger.Handler.(type) {
+	case *Handler:
+		handler.SetBufferSize(size)
+	case sink.MultiSink:
+		for _, s := range handler {
+			if bulkHandler, ok := s.(*Handler); ok {
+				bulkHandler.SetBufferSize(size)
+			}
+		}
+	}
 }
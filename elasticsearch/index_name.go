@@ -0,0 +1,76 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Matches the date patterns in an index name, eg. "es-queries-{yyyy.MM.dd}"
+var indexDatePattern = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// FormatIndexName evaluates the date patterns in the index name against the given time (in UTC), names without a
+// pattern are returned as is. Supported tokens are yyyy, yy, MM, dd, HH and ww (ISO week)
+func FormatIndexName(name string, t time.Time) string {
+	if !strings.Contains(name, "{") {
+		return name
+	}
+
+	t = t.UTC()
+
+	return indexDatePattern.ReplaceAllStringFunc(name, func(pattern string) string {
+		return formatDatePattern(pattern[1:len(pattern)-1], t)
+	})
+}
+
+func formatDatePattern(pattern string, t time.Time) string {
+	var formatted strings.Builder
+
+	for len(pattern) > 0 {
+		switch {
+		case strings.HasPrefix(pattern, "yyyy"):
+			formatted.WriteString(fmt.Sprintf("%04d", t.Year()))
+			pattern = pattern[4:]
+		case strings.HasPrefix(pattern, "yy"):
+			formatted.WriteString(fmt.Sprintf("%02d", t.Year()%100))
+			pattern = pattern[2:]
+		case strings.HasPrefix(pattern, "MM"):
+			formatted.WriteString(fmt.Sprintf("%02d", int(t.Month())))
+			pattern = pattern[2:]
+		case strings.HasPrefix(pattern, "dd"):
+			formatted.WriteString(fmt.Sprintf("%02d", t.Day()))
+			pattern = pattern[2:]
+		case strings.HasPrefix(pattern, "HH"):
+			formatted.WriteString(fmt.Sprintf("%02d", t.Hour()))
+			pattern = pattern[2:]
+		case strings.HasPrefix(pattern, "ww"):
+			_, week := t.ISOWeek()
+			formatted.WriteString(fmt.Sprintf("%02d", week))
+			pattern = pattern[2:]
+		default:
+			formatted.WriteByte(pattern[0])
+			pattern = pattern[1:]
+		}
+	}
+
+	return formatted.String()
+}
+
+// IndexPattern is the wildcard pattern matching every index written to by the given index name or write alias
+func IndexPattern(name string, writeAlias bool) string {
+	if writeAlias {
+		return name + "-*"
+	}
+
+	return indexDatePattern.ReplaceAllString(name, "*")
+}
+
+// IndexTemplateName is the index name without the date pattern, eg. "es-queries" for "es-queries-{yyyy.MM.dd}"
+func IndexTemplateName(name string) string {
+	if loc := indexDatePattern.FindStringIndex(name); loc != nil {
+		name = name[:loc[0]]
+	}
+
+	return strings.TrimRight(name, "-._")
+}
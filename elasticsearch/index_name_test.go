@@ -0,0 +1,96 @@
+package elasticsearch
+
+import (
+	"elasticsearch-proxy/config"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestFormatIndexName(t *testing.T) {
+	timestamp := time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		index    string
+		expected string
+	}{
+		{"plain index", "es-queries", "es-queries"},
+		{"daily", "es-queries-{yyyy.MM.dd}", "es-queries-2020.01.02"},
+		{"monthly", "es-queries-{yyyy-MM}", "es-queries-2020-01"},
+		{"hourly", "es-queries-{yyyy.MM.dd.HH}", "es-queries-2020.01.02.03"},
+		{"iso week", "es-queries-{yyyy}-w{ww}", "es-queries-2020-w01"},
+		{"short year", "es-queries-{yy.MM}", "es-queries-20.01"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if name := FormatIndexName(test.index, timestamp); name != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, name)
+			}
+		})
+	}
+
+	t.Run("uses utc", func(t *testing.T) {
+		local := time.Date(2020, time.January, 1, 23, 30, 0, 0, time.FixedZone("UTC-2", -2*60*60))
+
+		if name := FormatIndexName("es-queries-{yyyy.MM.dd}", local); name != "es-queries-2020.01.02" {
+			t.Errorf("expected the UTC date, got %q", name)
+		}
+	})
+}
+
+func TestIndexPattern(t *testing.T) {
+	tests := []struct {
+		name         string
+		index        string
+		writeAlias   bool
+		pattern      string
+		templateName string
+	}{
+		{"plain index", "es-queries", false, "es-queries", "es-queries"},
+		{"date based", "es-queries-{yyyy.MM.dd}", false, "es-queries-*", "es-queries"},
+		{"write alias", "es-queries", true, "es-queries-*", "es-queries"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if pattern := IndexPattern(test.index, test.writeAlias); pattern != test.pattern {
+				t.Errorf("expected pattern %q, got %q", test.pattern, pattern)
+			}
+
+			if name := IndexTemplateName(test.index); name != test.templateName {
+				t.Errorf("expected template name %q, got %q", test.templateName, name)
+			}
+		})
+	}
+}
+
+func TestBuildIndexTemplate(t *testing.T) {
+	queue := config.ElasticsearchIndexQueueConfig{Index: "es-queries", WriteAlias: true, MappingPath: "mapping.json"}
+	bootstrap := config.IndexBootstrapConfig{LifecyclePolicy: "zazu", RolloverMaxAge: "30d", DeleteAfter: "365d"}
+
+	template, err := BuildIndexTemplate(queue, bootstrap, []byte(`{"mapping":{"properties":{"message":{"type":"text"}}}}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, _ := json.Marshal(template)
+	expected := `{"index_patterns":["es-queries-*"],"mappings":{"properties":{"message":{"type":"text"}}},"settings":{"index.lifecycle.name":"zazu","index.lifecycle.rollover_alias":"es-queries"}}`
+
+	if string(encoded) != expected {
+		t.Errorf("expected %s, got %s", expected, encoded)
+	}
+
+	if _, err := BuildIndexTemplate(queue, bootstrap, []byte(`{"properties":{}}`)); err == nil {
+		t.Error("expected an error for a file without a mapping")
+	}
+
+	policy, _ := json.Marshal(BuildLifecyclePolicy(bootstrap))
+	expectedPolicy := `{"policy":{"phases":{"delete":{"actions":{"delete":{}},"min_age":"365d"},"hot":{"actions":{"rollover":{"max_age":"30d"}}}}}}`
+
+	if string(policy) != expectedPolicy {
+		t.Errorf("expected %s, got %s", expectedPolicy, policy)
+	}
+}
@@ -11,6 +11,9 @@ const MetricAgency = "agency"
 const MetricFeatures = "features"
 const MetricPropertySearch = "propertySearch"
 const MetricResponse = "response"
+const MetricPropertyView = "propertyView"
+const MetricResults = "results"
+const MetricAggregations = "aggregations"
 
 // Range types
 const MetricGuests = "guests"
@@ -0,0 +1,154 @@
+package elasticsearch
+
+import (
+	"github.com/tidwall/gjson"
+)
+
+// Fields which, when matched exactly with a term query, identify a single property. A plain "id" is too common on
+// other documents (eg. agencies) to be included, it is still picked up by the size 1 only clause check
+var PropertyIdFields = []string{"_id", "uuid", "uuid.keyword"}
+
+type MetricPropertyViewData struct {
+	PropertyId string `json:"propertyId"`
+	Field      string `json:"field"`
+}
+
+// The front-end also uses _msearch to pull single properties by their id, these are not searches and should not
+// pollute the search analytics. This checks the full query line (not just the query section as we need the size)
+// for an ids query, a term query on an id field or a size 1 query where an exact term match is the only clause.
+// The id has to be required (must/filter, or the only clause), an optional should clause may not match at all
+func ExtractPropertyView(fullQuery gjson.Result) (MetricPropertyViewData, bool) {
+	if !fullQuery.Exists() {
+		return MetricPropertyViewData{}, false
+	}
+
+	query := ParseActualQuery(fullQuery)
+
+	if view, found := findPropertyLookupRecursive(query); found {
+		return view, true
+	}
+
+	// A search for the first "active" property with a few other filters is still a search
+	if fullQuery.Get("size").Exists() && fullQuery.Get("size").Int() == 1 {
+		if term, only := findOnlyTermClause(query); only {
+			return extractTermLookup(term, true)
+		}
+	}
+
+	return MetricPropertyViewData{}, false
+}
+
+func findPropertyLookupRecursive(query gjson.Result) (MetricPropertyViewData, bool) {
+	var view MetricPropertyViewData
+	found := false
+
+	if !query.IsArray() && !query.IsObject() {
+		return view, false
+	}
+
+	query.ForEach(func(key, value gjson.Result) bool {
+		switch key.Str {
+		case "ids":
+			values := value.Get("values").Array()
+
+			// Pulling multiple records is a listing, not a view of a single property
+			if len(values) == 1 {
+				view, found = MetricPropertyViewData{PropertyId: values[0].String(), Field: "_id"}, true
+			}
+		case "term":
+			view, found = extractTermLookup(value, false)
+		case "bool":
+			view, found = findBoolLookup(value)
+		default:
+			if CanDescend(key) {
+				view, found = findPropertyLookupRecursive(value)
+			}
+		}
+
+		return !found
+	})
+
+	return view, found
+}
+
+// Only the required clauses are checked, a single should clause is required when there is nothing else in the bool
+func findBoolLookup(boolQuery gjson.Result) (MetricPropertyViewData, bool) {
+	for _, occur := range []string{"must", "filter"} {
+		if view, found := findPropertyLookupRecursive(boolQuery.Get(occur)); found {
+			return view, true
+		}
+	}
+
+	should := boolQuery.Get("should")
+
+	if len(boolQuery.Map()) == 1 && (should.IsObject() || len(should.Array()) == 1) {
+		return findPropertyLookupRecursive(should)
+	}
+
+	return MetricPropertyViewData{}, false
+}
+
+func extractTermLookup(term gjson.Result, anyField bool) (MetricPropertyViewData, bool) {
+	var view MetricPropertyViewData
+	found := false
+
+	term.ForEach(func(field, value gjson.Result) bool {
+		// Terms can either be the value itself or the long form {"value": "..."}
+		if value.IsObject() {
+			value = value.Get("value")
+		}
+
+		if !value.Exists() || value.String() == "" {
+			return true
+		}
+
+		if IsPropertyIdField(field.Str) || anyField {
+			view, found = MetricPropertyViewData{PropertyId: value.String(), Field: field.Str}, true
+		}
+
+		return !found
+	})
+
+	return view, found
+}
+
+// Unwraps bool queries with a single must/filter clause (and nothing else) down to a term query
+func findOnlyTermClause(query gjson.Result) (gjson.Result, bool) {
+	if !query.IsObject() || len(query.Map()) != 1 {
+		return gjson.Result{}, false
+	}
+
+	if term := query.Get("term"); term.Exists() {
+		return term, len(term.Map()) == 1
+	}
+
+	boolQuery := query.Get("bool")
+
+	if !boolQuery.IsObject() || len(boolQuery.Map()) != 1 {
+		return gjson.Result{}, false
+	}
+
+	for _, occur := range []string{"must", "filter"} {
+		clause := boolQuery.Get(occur)
+
+		if clause.IsArray() {
+			if clauses := clause.Array(); len(clauses) == 1 {
+				return findOnlyTermClause(clauses[0])
+			}
+		} else if clause.IsObject() {
+			return findOnlyTermClause(clause)
+		}
+	}
+
+	return gjson.Result{}, false
+}
+
+func IsPropertyIdField(field string) bool {
+	for _, idField := range PropertyIdFields {
+		if field == idField {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,47 @@
+package elasticsearch
+
+import (
+	"github.com/tidwall/gjson"
+	"testing"
+)
+
+func TestExtractPropertyView(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		isView     bool
+		propertyId string
+	}{
+		{"ids query", `{"query":{"ids":{"values":["abc-123"]}}}`, true, "abc-123"},
+		{"ids query with multiple values", `{"query":{"ids":{"values":["abc-123","def-456"]}}}`, false, ""},
+		{"term on _id", `{"query":{"bool":{"filter":[{"term":{"_id":"abc-123"}}]}}}`, true, "abc-123"},
+		{"term on uuid long form", `{"query":{"term":{"uuid":{"value":"abc-123"}}}}`, true, "abc-123"},
+		{"size 1 with exact match", `{"size":1,"query":{"bool":{"must":[{"term":{"slug.keyword":"sea-view-cottage"}}]}}}`, true, "sea-view-cottage"},
+		{"size 1 with a single term", `{"size":1,"query":{"term":{"slug.keyword":{"value":"sea-view-cottage"}}}}`, true, "sea-view-cottage"},
+		{"size 1 with other clauses is a search", `{"size":1,"query":{"bool":{"must":[{"term":{"status":"active"}},{"range":{"listing.bedrooms":{"gte":2}}}]}}}`, false, ""},
+		{"size 1 with a term and a filter is a search", `{"size":1,"query":{"bool":{"must":[{"term":{"status":"active"}}],"filter":[{"geo_distance":{"distance":"10km","location":[-5.05,50.26]}}]}}}`, false, ""},
+		{"size 1 with should clauses is a search", `{"size":1,"query":{"bool":{"should":[{"term":{"status":"active"}}]}}}`, false, ""},
+		{"id term among other clauses", `{"size":1,"query":{"bool":{"must":[{"term":{"status":"active"}},{"term":{"uuid":"abc-123"}}]}}}`, true, "abc-123"},
+		{"optional id term with other criteria is a search", `{"query":{"bool":{"should":[{"term":{"uuid":"abc-123"}},{"match":{"title":"sea view"}}],"must":[{"range":{"listing.bedrooms":{"gte":2}}}]}}}`, false, ""},
+		{"only clause should id term", `{"query":{"bool":{"should":[{"term":{"uuid":"abc-123"}}]}}}`, true, "abc-123"},
+		{"term on plain id is a search", `{"size":20,"query":{"bool":{"filter":[{"term":{"id":"123"}},{"term":{"status":"active"}}]}}}`, false, ""},
+		{"size 1 with only a plain id term", `{"size":1,"query":{"term":{"id":"123"}}}`, true, "123"},
+		{"term on other field is a search", `{"size":20,"query":{"bool":{"must":[{"term":{"slug.keyword":"sea-view-cottage"}}]}}}`, false, ""},
+		{"range search", `{"query":{"bool":{"must":[{"range":{"listing.bedrooms":{"gte":2,"lte":3}}}]}}}`, false, ""},
+		{"function score wrapped ids", `{"query":{"function_score":{"query":{"ids":{"values":["abc-123"]}}}}}`, true, "abc-123"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			view, isView := ExtractPropertyView(gjson.Parse(test.query))
+
+			if isView != test.isView {
+				t.Fatalf("expected isView %v, got %v", test.isView, isView)
+			}
+
+			if view.PropertyId != test.propertyId {
+				t.Errorf("expected property id %q, got %q", test.propertyId, view.PropertyId)
+			}
+		})
+	}
+}
@@ -45,6 +45,17 @@ func GetHash(data string) string {
 func ParseQueries(body string) []gjson.Result {
 	var results []gjson.Result
 
+	for _, queryLine := range ParseQueryLines(body) {
+		results = append(results, ParseActualQuery(queryLine))
+	}
+
+	return results
+}
+
+// Returns the full valid query lines, ParseQueries should be used when only the query section is required
+func ParseQueryLines(body string) []gjson.Result {
+	var results []gjson.Result
+
 	// Elasticsearch queries can have multiple queries in a single request
 	queries := ParseJsonBodyLines(body)
 
@@ -61,9 +72,7 @@ func ParseQueries(body string) []gjson.Result {
 			continue
 		}
 
-		actualQuery := ParseActualQuery(queryLine)
-
-		results = append(results, actualQuery)
+		results = append(results, queryLine)
 	}
 
 	return results
@@ -0,0 +1,108 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"github.com/tidwall/gjson"
+)
+
+// Elasticsearch defaults when from/size are not provided
+const DefaultPageSize = 10
+
+type MetricResultsData struct {
+	Hits     []ResultHitData `json:"hits"`
+	Sort     []string        `json:"sort"`
+	From     int64           `json:"from"`
+	PageSize int64           `json:"pageSize"`
+	Page     int64           `json:"page"`
+	MaxScore float64         `json:"maxScore"`
+}
+
+type ResultHitData struct {
+	Id       string  `json:"id"`
+	Position int64   `json:"position"`
+	Score    float64 `json:"score"`
+}
+
+// Captures what was actually shown to the visitor, combined with property views this gives us impressions and
+// click-through. The full query line is required as from, size and sort live outside of the query section
+func ExtractQueryResultMetrics(queryLine gjson.Result, queryResponse gjson.Result, maxHits int, metrics map[string]interface{}) {
+	if !queryResponse.Exists() {
+		return
+	}
+
+	from := queryLine.Get("from").Int()
+	pageSize := int64(DefaultPageSize)
+
+	if size := queryLine.Get("size"); size.Exists() {
+		pageSize = size.Int()
+	}
+
+	var page int64 = 1
+	if pageSize > 0 {
+		page = from/pageSize + 1
+	}
+
+	hits := make([]ResultHitData, 0)
+
+	for index, hit := range queryResponse.Get("hits.hits").Array() {
+		if maxHits > 0 && index >= maxHits {
+			break
+		}
+
+		hits = append(hits, ResultHitData{
+			Id:       hit.Get("_id").String(),
+			Position: from + int64(index) + 1,
+			Score:    hit.Get("_score").Float(),
+		})
+	}
+
+	metrics[MetricResults] = MetricResultsData{
+		Hits:     hits,
+		Sort:     ParseSort(queryLine.Get("sort")),
+		From:     from,
+		PageSize: pageSize,
+		Page:     page,
+		MaxScore: queryResponse.Get("hits.max_score").Float(),
+	}
+}
+
+// Sorts can be a single field, a list of fields or a list of objects with an order, these are flattened into
+// "field:order" strings so they can be aggregated on
+func ParseSort(sort gjson.Result) []string {
+	sorts := make([]string, 0)
+
+	if !sort.Exists() {
+		return sorts
+	}
+
+	var items []gjson.Result
+	if sort.IsArray() {
+		items = sort.Array()
+	} else {
+		items = []gjson.Result{sort}
+	}
+
+	for _, item := range items {
+		if !item.IsObject() {
+			sorts = append(sorts, item.String())
+			continue
+		}
+
+		item.ForEach(func(field, value gjson.Result) bool {
+			order := value.String()
+			if value.IsObject() {
+				order = value.Get("order").String()
+			}
+
+			if order == "" {
+				sorts = append(sorts, field.Str)
+			} else {
+				sorts = append(sorts, fmt.Sprintf("%s:%s", field.Str, order))
+			}
+
+			return true
+		})
+	}
+
+	return sorts
+}
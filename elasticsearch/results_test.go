@@ -0,0 +1,51 @@
+package elasticsearch
+
+import (
+	"github.com/tidwall/gjson"
+	"testing"
+)
+
+func TestExtractQueryResultMetrics(t *testing.T) {
+	queryLine := gjson.Parse(`{"from":20,"size":10,"sort":[{"pricing.visual.nightlyLow":{"order":"asc"}},"_score"],"query":{"match_all":{}}}`)
+	response := gjson.Parse(`{"took":5,"hits":{"total":{"value":42},"max_score":1.5,"hits":[{"_id":"a","_score":1.5},{"_id":"b","_score":1.2},{"_id":"c","_score":0.7}]}}`)
+
+	t.Run("hits, page and sort are extracted", func(t *testing.T) {
+		metrics := make(map[string]interface{})
+		ExtractQueryResultMetrics(queryLine, response, 0, metrics)
+
+		results, ok := metrics[MetricResults].(MetricResultsData)
+		if !ok {
+			t.Fatal("Incorrect interface for results metric")
+		}
+
+		if results.Page != 3 || results.PageSize != 10 || results.MaxScore != 1.5 {
+			t.Errorf("unexpected paging data: %+v", results)
+		}
+
+		if len(results.Hits) != 3 || results.Hits[0].Id != "a" || results.Hits[0].Position != 21 || results.Hits[2].Position != 23 {
+			t.Errorf("unexpected hits: %+v", results.Hits)
+		}
+
+		if len(results.Sort) != 2 || results.Sort[0] != "pricing.visual.nightlyLow:asc" || results.Sort[1] != "_score" {
+			t.Errorf("unexpected sort: %v", results.Sort)
+		}
+	})
+
+	t.Run("max hits limits the captured ids", func(t *testing.T) {
+		metrics := make(map[string]interface{})
+		ExtractQueryResultMetrics(queryLine, response, 2, metrics)
+
+		if len(metrics[MetricResults].(MetricResultsData).Hits) != 2 {
+			t.Fail()
+		}
+	})
+
+	t.Run("missing response is ignored", func(t *testing.T) {
+		metrics := make(map[string]interface{})
+		ExtractQueryResultMetrics(queryLine, gjson.Result{}, 0, metrics)
+
+		if len(metrics) != 0 {
+			t.Fail()
+		}
+	})
+}
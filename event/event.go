@@ -0,0 +1,129 @@
+package event
+
+import (
+	"elasticsearch-proxy/geo"
+	"elasticsearch-proxy/lycan"
+	"elasticsearch-proxy/useragent"
+	"encoding/json"
+	"fmt"
+	"github.com/apex/log"
+	"time"
+)
+
+// Version 1 was the serialised apex log.Entry (level, message and everything nested under "fields"). Bump this
+// whenever a field is renamed or changes type so consumers can tell the documents apart
+const SchemaVersion = 2
+
+// Attributes shared by every analytics event, the names match the old "fields.*" paths without the prefix
+type Base struct {
+	SchemaVersion int                  `json:"schemaVersion"`
+	Timestamp     time.Time            `json:"timestamp"`
+	Type          string               `json:"type"`
+	Url           string               `json:"url"`
+	Host          string               `json:"host"`
+	App           string               `json:"app"`
+	Ip            string               `json:"ip"`
+	Index         string               `json:"index"`
+	UserAgent     string               `json:"userAgent"`
+	RequestId     string               `json:"requestId,omitempty"`
+	TraceId       string               `json:"traceId,omitempty"`
+	Geo           *geo.Location        `json:"geo,omitempty"`
+	Ua            *useragent.UserAgent `json:"ua,omitempty"`
+	RejectedBy    string               `json:"rejectedBy,omitempty"`
+}
+
+// Searches and property views, data holds the extracted query metrics keyed by metric name
+type SearchEvent struct {
+	Base
+	RawQuery    string                 `json:"rawQuery,omitempty"`
+	PropertyId  string                 `json:"propertyId,omitempty"`
+	ZeroResults bool                   `json:"zeroResults"`
+	Data        map[string]interface{} `json:"data"`
+	Refinements interface{}            `json:"refinements,omitempty"`
+}
+
+type PriceRequestEvent struct {
+	Base
+	RawParams string                 `json:"rawParams,omitempty"`
+	Data      lycan.PriceRequestData `json:"data"`
+}
+
+// FromEntry builds the typed event from a queued log entry, the type is decided by the data it carries so
+// rejected and zero result entries are encoded the same way as the ones they were copied from
+func FromEntry(entry *log.Entry) interface{} {
+	base := newBase(entry)
+
+	switch data := entry.Fields.Get("data").(type) {
+	case lycan.PriceRequestData:
+		return PriceRequestEvent{
+			Base:      base,
+			RawParams: stringField(entry.Fields, "rawParams"),
+			Data:      data,
+		}
+	case map[string]interface{}:
+		return newSearchEvent(base, entry.Fields, data)
+	}
+
+	return newSearchEvent(base, entry.Fields, map[string]interface{}{})
+}
+
+// Marshal encodes the entry as its typed event, used by every sink so the documents are the same everywhere
+func Marshal(entry *log.Entry) ([]byte, error) {
+	return json.Marshal(FromEntry(entry))
+}
+
+func newBase(entry *log.Entry) Base {
+	base := Base{
+		SchemaVersion: SchemaVersion,
+		Timestamp:     entry.Timestamp.UTC(),
+		Type:          stringField(entry.Fields, "type"),
+		Url:           stringField(entry.Fields, "url"),
+		Host:          stringField(entry.Fields, "host"),
+		App:           stringField(entry.Fields, "app"),
+		Ip:            stringField(entry.Fields, "ip"),
+		Index:         stringField(entry.Fields, "index"),
+		UserAgent:     stringField(entry.Fields, "userAgent"),
+		RequestId:     stringField(entry.Fields, "requestId"),
+		TraceId:       stringField(entry.Fields, "traceId"),
+		RejectedBy:    stringField(entry.Fields, "rejectedBy"),
+	}
+
+	// The queues log the URL as the message
+	if base.Url == "" {
+		base.Url = entry.Message
+	}
+
+	if location, ok := entry.Fields.Get("geo").(geo.Location); ok {
+		base.Geo = &location
+	}
+
+	if userAgent, ok := entry.Fields.Get("ua").(useragent.UserAgent); ok {
+		base.Ua = &userAgent
+	}
+
+	return base
+}
+
+func newSearchEvent(base Base, fields log.Fields, data map[string]interface{}) SearchEvent {
+	zeroResults, _ := fields.Get("zeroResults").(bool)
+
+	return SearchEvent{
+		Base:        base,
+		RawQuery:    stringField(fields, "rawQuery"),
+		PropertyId:  stringField(fields, "propertyId"),
+		ZeroResults: zeroResults,
+		Data:        data,
+		Refinements: fields.Get("refinements"),
+	}
+}
+
+func stringField(fields log.Fields, name string) string {
+	switch value := fields.Get(name).(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
@@ -0,0 +1,104 @@
+package event
+
+import (
+	"elasticsearch-proxy/geo"
+	"elasticsearch-proxy/lycan"
+	"encoding/json"
+	"github.com/apex/log"
+	"testing"
+	"time"
+)
+
+func TestFromEntry(t *testing.T) {
+	timestamp := time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		entry    log.Entry
+		expected string
+	}{
+		{
+			"search",
+			log.Entry{
+				Message:   "/properties/_msearch",
+				Timestamp: timestamp,
+				Fields: log.Fields{
+					"type":        "ELASTICSEARCH",
+					"url":         "/properties/_msearch",
+					"ip":          "192.0.2.1",
+					"index":       "properties",
+					"requestId":   "req-1",
+					"traceId":     "4bf92f3577b34da6a3ce929d0e0e4736",
+					"rawQuery":    `{"query":{}}`,
+					"zeroResults": true,
+					"geo":         geo.Location{CountryCode: "GB"},
+					"data":        map[string]interface{}{"guests": map[string]int{"minimum": 2}},
+				},
+			},
+			`{"schemaVersion":2,"timestamp":"2020-01-02T03:04:05Z","type":"ELASTICSEARCH","url":"/properties/_msearch","host":"","app":"","ip":"192.0.2.1","index":"properties","userAgent":"","requestId":"req-1","traceId":"4bf92f3577b34da6a3ce929d0e0e4736","geo":{"country":"","countryCode":"GB","region":"","city":"","location":{"lat":0,"lon":0}},"rawQuery":"{\"query\":{}}","zeroResults":true,"data":{"guests":{"minimum":2}}}`,
+		},
+		{
+			"rejected property view",
+			log.Entry{
+				Message:   "/properties/_search",
+				Timestamp: timestamp,
+				Fields: log.Fields{
+					"type":       "PROPERTY_VIEW",
+					"propertyId": "abc-123",
+					"rejectedBy": "crawler",
+					"data":       map[string]interface{}{},
+				},
+			},
+			`{"schemaVersion":2,"timestamp":"2020-01-02T03:04:05Z","type":"PROPERTY_VIEW","url":"/properties/_search","host":"","app":"","ip":"","index":"","userAgent":"","rejectedBy":"crawler","propertyId":"abc-123","zeroResults":false,"data":{}}`,
+		},
+		{
+			"price request",
+			log.Entry{
+				Message:   "/api/price",
+				Timestamp: timestamp,
+				Fields: log.Fields{
+					"type":      "PRICE_REQUEST",
+					"rawParams": "guests=2",
+					"data":      lycan.PriceRequestData{Property: lycan.PropertyData{Uuid: "abc-123"}},
+				},
+			},
+			``,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded, err := Marshal(&test.entry)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.expected != "" && string(encoded) != test.expected {
+				t.Errorf("expected\n%s\ngot\n%s", test.expected, encoded)
+			}
+
+			var document map[string]interface{}
+			json.Unmarshal(encoded, &document)
+
+			for _, apexField := range []string{"fields", "level", "message"} {
+				if _, exists := document[apexField]; exists {
+					t.Errorf("expected no %q attribute, got %s", apexField, encoded)
+				}
+			}
+		})
+	}
+
+	t.Run("price request data stays typed", func(t *testing.T) {
+		entry := tests[2].entry
+		priceEvent, ok := FromEntry(&entry).(PriceRequestEvent)
+
+		if !ok {
+			t.Fatalf("expected a PriceRequestEvent, got %T", FromEntry(&entry))
+		}
+
+		if priceEvent.Data.Property.Uuid != "abc-123" || priceEvent.RawParams != "guests=2" || priceEvent.Url != "/api/price" {
+			t.Errorf("unexpected event %+v", priceEvent)
+		}
+	})
+}
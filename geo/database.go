@@ -0,0 +1,144 @@
+package geo
+
+import (
+	"github.com/apex/log"
+	"github.com/oschwald/geoip2-golang"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+type Location struct {
+	Country     string   `json:"country"`
+	CountryCode string   `json:"countryCode"`
+	Region      string   `json:"region"`
+	City        string   `json:"city"`
+	GeoPoint    GeoPoint `json:"location"`
+}
+
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// Wraps a local MaxMind/GeoLite city database, the file is checked for modifications on an interval and
+// re-opened so it can be updated (eg. by geoipupdate) without restarting the proxy
+type Database struct {
+	Path string
+
+	reader  *geoip2.Reader
+	modTime time.Time
+	mu      *sync.RWMutex
+}
+
+func NewDatabase(path string, reloadInterval time.Duration) (*Database, error) {
+	db := &Database{
+		Path: path,
+		mu:   &sync.RWMutex{},
+	}
+
+	if err := db.Reload(); err != nil {
+		return nil, err
+	}
+
+	if reloadInterval > 0 {
+		go db.RunReloadRoutine(reloadInterval)
+	}
+
+	return db, nil
+}
+
+func (db *Database) RunReloadRoutine(interval time.Duration) {
+	for {
+		select {
+		case <-time.After(interval):
+			info, err := os.Stat(db.Path)
+
+			if err != nil {
+				log.Error("Could not stat geo database: " + err.Error())
+				continue
+			}
+
+			db.mu.RLock()
+			changed := !info.ModTime().Equal(db.modTime)
+			db.mu.RUnlock()
+
+			if !changed {
+				continue
+			}
+
+			if err := db.Reload(); err != nil {
+				// Keep using the previous database, the file may still be mid-write
+				log.Error("Could not reload geo database: " + err.Error())
+			} else {
+				log.Info("Reloaded geo database: " + db.Path)
+			}
+		}
+	}
+}
+
+func (db *Database) Reload() error {
+	info, err := os.Stat(db.Path)
+
+	if err != nil {
+		return err
+	}
+
+	reader, err := geoip2.Open(db.Path)
+
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	previous := db.reader
+	db.reader = reader
+	db.modTime = info.ModTime()
+	db.mu.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+
+	return nil
+}
+
+func (db *Database) Lookup(ip string) (Location, bool) {
+	parsed := net.ParseIP(ip)
+
+	if parsed == nil {
+		return Location{}, false
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	record, err := db.reader.City(parsed)
+
+	if err != nil {
+		log.Debug("Geo lookup failed for " + ip + ": " + err.Error())
+		return Location{}, false
+	}
+
+	// Private or unknown addresses produce an empty record rather than an error
+	if record.Country.IsoCode == "" && record.City.GeoNameID == 0 {
+		return Location{}, false
+	}
+
+	location := Location{
+		Country:     record.Country.Names["en"],
+		CountryCode: record.Country.IsoCode,
+		City:        record.City.Names["en"],
+		GeoPoint: GeoPoint{
+			Lat: record.Location.Latitude,
+			Lon: record.Location.Longitude,
+		},
+	}
+
+	if len(record.Subdivisions) > 0 {
+		location.Region = record.Subdivisions[0].Names["en"]
+	}
+
+	return location, true
+}
@@ -0,0 +1,205 @@
+package geo
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geo")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "GeoLite2-City.mmdb")
+	writeTestDatabase(t, path, "London", time.Now().Add(-time.Hour))
+
+	db, err := NewDatabase(path, 10*time.Millisecond)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("looks up the city", func(t *testing.T) {
+		location, ok := db.Lookup("81.2.69.142")
+
+		expected := Location{Country: "United Kingdom", CountryCode: "GB", Region: "England", City: "London", GeoPoint: GeoPoint{Lat: 51.5142, Lon: -0.0931}}
+
+		if !ok || location != expected {
+			t.Errorf("expected %+v, got %+v", expected, location)
+		}
+	})
+
+	t.Run("unknown and invalid addresses are not found", func(t *testing.T) {
+		for _, ip := range []string{"1.2.3.4", "not an ip"} {
+			if location, ok := db.Lookup(ip); ok {
+				t.Errorf("expected %s not to be found, got %+v", ip, location)
+			}
+		}
+	})
+
+	t.Run("an invalid file keeps the current reader", func(t *testing.T) {
+		invalid := filepath.Join(dir, "invalid.mmdb")
+
+		if err := ioutil.WriteFile(invalid, []byte("half written"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		broken := &Database{Path: invalid, reader: db.reader, mu: db.mu}
+
+		if err := broken.Reload(); err == nil {
+			t.Error("expected the invalid database to be rejected")
+		}
+
+		if location, ok := broken.Lookup("81.2.69.142"); !ok || location.City != "London" {
+			t.Errorf("expected the previous reader to still be used, got %+v", location)
+		}
+	})
+
+	t.Run("the reader is swapped when the file changes", func(t *testing.T) {
+		writeTestDatabase(t, path, "Manchester", time.Now())
+
+		deadline := time.Now().Add(2 * time.Second)
+
+		for {
+			if location, _ := db.Lookup("81.2.69.142"); location.City == "Manchester" {
+				break
+			}
+
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for the database to be reloaded")
+			}
+
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+}
+
+func TestNewDatabaseMissingFile(t *testing.T) {
+	if _, err := NewDatabase(filepath.Join(os.TempDir(), "does-not-exist.mmdb"), 0); err == nil {
+		t.Error("expected an error for a missing database")
+	}
+}
+
+// Writes a minimal IPv4 MaxMind DB (https://maxmind.github.io/MaxMind-DB/) mapping 81.0.0.0/8 to the city, it is
+// renamed into place like geoipupdate does
+func writeTestDatabase(t *testing.T, path string, city string, modTime time.Time) {
+	const nodeCount = 8
+	const prefix = 81
+
+	var tree []byte
+
+	for i := 0; i < nodeCount; i++ {
+		next := uint32(i + 1)
+
+		// The last node points at the only record in the data section
+		if i == nodeCount-1 {
+			next = nodeCount + 16
+		}
+
+		left, right := next, uint32(nodeCount)
+
+		if prefix&(0x80>>uint(i)) != 0 {
+			left, right = right, left
+		}
+
+		tree = append(tree, byte(left>>16), byte(left>>8), byte(left), byte(right>>16), byte(right>>8), byte(right))
+	}
+
+	record := map[string]interface{}{
+		"city":         map[string]interface{}{"geoname_id": uint32(2643743), "names": map[string]interface{}{"en": city}},
+		"country":      map[string]interface{}{"iso_code": "GB", "names": map[string]interface{}{"en": "United Kingdom"}},
+		"location":     map[string]interface{}{"latitude": 51.5142, "longitude": -0.0931},
+		"subdivisions": []interface{}{map[string]interface{}{"names": map[string]interface{}{"en": "England"}}},
+	}
+
+	metadata := map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"database_type":               "GeoLite2-City",
+		"ip_version":                  uint16(4),
+		"languages":                   []interface{}{"en"},
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(24),
+	}
+
+	content := append(tree, make([]byte, 16)...)
+	content = append(content, encodeMmdb(record)...)
+	content = append(content, []byte("\xAB\xCD\xEFMaxMind.com")...)
+	content = append(content, encodeMmdb(metadata)...)
+
+	tmp := path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(tmp, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Only the types and sizes (under 29) needed by the test records are supported
+func encodeMmdb(value interface{}) []byte {
+	control := func(dataType int, size int) []byte {
+		if dataType > 7 {
+			return []byte{byte(size), byte(dataType - 7)}
+		}
+
+		return []byte{byte(dataType<<5 | size)}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return append(control(2, len(v)), v...)
+	case float64:
+		encoded := make([]byte, 8)
+		binary.BigEndian.PutUint64(encoded, math.Float64bits(v))
+
+		return append(control(3, 8), encoded...)
+	case uint16:
+		return append(control(5, 2), byte(v>>8), byte(v))
+	case uint32:
+		return append(control(6, 4), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+
+		for key := range v {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		encoded := control(7, len(v))
+
+		for _, key := range keys {
+			encoded = append(encoded, encodeMmdb(key)...)
+			encoded = append(encoded, encodeMmdb(v[key])...)
+		}
+
+		return encoded
+	case []interface{}:
+		encoded := control(11, len(v))
+
+		for _, item := range v {
+			encoded = append(encoded, encodeMmdb(item)...)
+		}
+
+		return encoded
+	}
+
+	panic("unsupported type")
+}
@@ -3,10 +3,13 @@ module elasticsearch-proxy
 go 1.14
 
 require (
+	github.com/Shopify/sarama v1.26.1
 	github.com/andybalholm/brotli v1.0.0
 	github.com/apex/log v1.1.2
 	github.com/caddyserver/certmagic v0.10.11
 	github.com/elastic/go-elasticsearch/v7 v7.5.1-0.20200409075911-14061b088525
+	github.com/klauspost/compress v1.9.8
+	github.com/oschwald/geoip2-golang v1.4.0
 	github.com/samvaughton/crawlerdetection v0.1.1
 	github.com/tidwall/gjson v1.6.0
 	gopkg.in/yaml.v2 v2.2.8
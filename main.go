@@ -4,10 +4,14 @@ import (
 	"elasticsearch-proxy/config"
 	"elasticsearch-proxy/elasticsearch"
 	"elasticsearch-proxy/proxy"
+	"elasticsearch-proxy/util"
 	"flag"
+	"fmt"
 	"github.com/apex/log"
 	"github.com/apex/log/handlers/text"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 var configLocationFlag = flag.String(
@@ -16,20 +20,59 @@ var configLocationFlag = flag.String(
 	"Specifies the configuration file location.",
 )
 
+var checkConfigFlag = flag.Bool(
+	"check-config",
+	false,
+	"Validates the configuration file and exits, non-zero if it is invalid.",
+)
+
 func main() {
 	// Config Parsing
 	flag.Parse()
 	cfg, err := config.LoadFromFile(*configLocationFlag)
 
 	if err != nil {
-		log.Fatalf(err.Error())
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
 	}
 
-	// Set logging
-	log.SetLevelFromString(cfg.Logging.Level)
-	log.SetHandler(text.New(os.Stdout))
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	if *checkConfigFlag {
+		fmt.Println("Config OK: " + *configLocationFlag)
+		os.Exit(0)
+	}
+
+	// Set logging, the level is applied by the handler so it can be changed on reload
+	levelHandler := util.NewLevelHandler(text.New(os.Stdout), log.MustParseLevel(cfg.Logging.Level))
+	log.SetHandler(levelHandler)
+	log.SetLevel(log.DebugLevel)
 
 	elasticsearch.ConfigureLoggers(cfg)
 
+	config.OnReload(func(previous config.Config, current config.Config) {
+		levelHandler.SetLevel(log.MustParseLevel(current.Logging.Level))
+	})
+
+	// Reloadable settings are re-read on SIGHUP or when the file changes
+	go config.Watch(*configLocationFlag, cfg, config.DefaultWatchInterval)
+
+	go closeSinksOnShutdown()
+
 	proxy.ConfigureAndStartProxyServer(cfg)
 }
+
+// The file, webhook and kafka sinks batch entries, these are flushed before exiting
+func closeSinksOnShutdown() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	received := <-signals
+	log.Info("Received " + received.String() + ", closing sinks")
+
+	elasticsearch.CloseSinks()
+	os.Exit(0)
+}
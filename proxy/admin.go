@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"elasticsearch-proxy/config"
+	"encoding/json"
+	"github.com/apex/log"
+	"net/http"
+	"strings"
+)
+
+// Admin endpoints live under a prefix that can never be a valid Elasticsearch or Lycan path
+const AdminPathPrefix = "/_zazu/"
+
+func NewAdminHandler(cfg config.AdminConfig, handler http.Handler) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+
+		// Fails closed, an empty token would otherwise let anyone in
+		if cfg.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Token)) != 1 {
+			http.Error(res, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(res, req)
+	}
+}
+
+func WriteJsonResponse(res http.ResponseWriter, data interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(res).Encode(data); err != nil {
+		log.Error("Could not encode admin response: " + err.Error())
+	}
+}
@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"elasticsearch-proxy/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminHandler(t *testing.T) {
+	ok := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		expected      int
+	}{
+		{"valid token", "secret", "Bearer secret", http.StatusOK},
+		{"wrong token", "secret", "Bearer guess", http.StatusUnauthorized},
+		{"missing header", "secret", "", http.StatusUnauthorized},
+		{"no token configured", "", "", http.StatusUnauthorized},
+		{"no token configured with an empty bearer", "", "Bearer ", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", AdminPathPrefix+"zero-results", nil)
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+
+			res := httptest.NewRecorder()
+			NewAdminHandler(config.AdminConfig{Enabled: true, Token: test.token}, ok)(res, req)
+
+			if res.Code != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, res.Code)
+			}
+		})
+	}
+}
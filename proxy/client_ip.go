@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"context"
+	"elasticsearch-proxy/config"
+	"fmt"
+	"github.com/apex/log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// In production we sit behind a load balancer so RemoteAddr is not the visitor, the forwarding headers are
+// only believed when the request came through one of the configured trusted proxies
+
+type contextKey string
+
+const clientIpContextKey contextKey = "clientIp"
+
+type TrustedProxies struct {
+	Networks IpNetworks
+
+	// X-Forwarded-For, Forwarded or X-Real-IP, the others are ignored as the client could have set them
+	Header string
+}
+
+type IpNetworks []*net.IPNet
+
+func ParseTrustedProxies(cidrs []string, header string) (TrustedProxies, error) {
+	networks, err := ParseIpNetworks(cidrs)
+
+	if err != nil {
+		return TrustedProxies{}, fmt.Errorf("invalid trusted proxy: %v", err)
+	}
+
+	return TrustedProxies{Networks: networks, Header: header}, nil
+}
+
+func ParseIpNetworks(cidrs []string) (IpNetworks, error) {
+	networks := make(IpNetworks, 0)
+
+	for _, cidr := range cidrs {
+		// Allow single addresses as well as ranges
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", cidr, err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+func (n IpNetworks) Contains(ip string) bool {
+	parsed := net.ParseIP(ip)
+
+	if parsed == nil {
+		return false
+	}
+
+	for _, network := range n {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (tp TrustedProxies) IsTrusted(ip string) bool {
+	return tp.Networks.Contains(ip)
+}
+
+// Walks the forwarding chain of the configured header from the closest hop backwards, the first address that is
+// not a trusted proxy is the client. X-Real-IP holds a single address which the trusted proxy has to overwrite
+func (tp TrustedProxies) ClientIp(req *http.Request) string {
+	remoteIp := RemoteIp(req)
+
+	if !tp.IsTrusted(remoteIp) {
+		return remoteIp
+	}
+
+	var chain []string
+
+	switch tp.Header {
+	case config.ClientIpForwarded:
+		chain = ParseForwardedFor(req.Header.Values("Forwarded"))
+	case config.ClientIpXRealIp:
+		if realIp := net.ParseIP(strings.TrimSpace(req.Header.Get("X-Real-IP"))); realIp != nil {
+			return realIp.String()
+		}
+	default:
+		chain = ParseXForwardedFor(req.Header.Values("X-Forwarded-For"))
+	}
+
+	if len(chain) == 0 {
+		return remoteIp
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		if !tp.IsTrusted(chain[i]) {
+			return chain[i]
+		}
+	}
+
+	// Every hop is trusted, the furthest one is the best we have
+	return chain[0]
+}
+
+func RemoteIp(req *http.Request) string {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+
+	if err != nil {
+		log.Error("Failed to split the host/port on remote address: " + req.RemoteAddr)
+	}
+
+	return ip
+}
+
+func ParseXForwardedFor(headers []string) []string {
+	chain := make([]string, 0)
+
+	for _, header := range headers {
+		for _, part := range strings.Split(header, ",") {
+			if ip := normalizeForwardedAddress(part); ip != "" {
+				chain = append(chain, ip)
+			}
+		}
+	}
+
+	return chain
+}
+
+// Forwarded: for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8:cafe::17]:4711"
+func ParseForwardedFor(headers []string) []string {
+	chain := make([]string, 0)
+
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+
+				if len(parts) != 2 || !strings.EqualFold(parts[0], "for") {
+					continue
+				}
+
+				// Obfuscated identifiers and "unknown" are skipped, they cannot be used as an IP
+				if ip := normalizeForwardedAddress(parts[1]); ip != "" {
+					chain = append(chain, ip)
+				}
+			}
+		}
+	}
+
+	return chain
+}
+
+func normalizeForwardedAddress(address string) string {
+	address = strings.Trim(strings.TrimSpace(address), `"`)
+
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+
+	address = strings.Trim(address, "[]")
+
+	if ip := net.ParseIP(address); ip != nil {
+		return ip.String()
+	}
+
+	return ""
+}
+
+func WithClientIp(req *http.Request, ip string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), clientIpContextKey, ip))
+}
+
+// The client IP is resolved once when the request is received, falls back to the remote address
+func GetClientIp(req *http.Request) string {
+	if ip, ok := req.Context().Value(clientIpContextKey).(string); ok {
+		return ip
+	}
+
+	return RemoteIp(req)
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"elasticsearch-proxy/config"
+	"net/http"
+	"testing"
+)
+
+func TestClientIp(t *testing.T) {
+	xff, forwarded, realIp := config.ClientIpXForwardedFor, config.ClientIpForwarded, config.ClientIpXRealIp
+
+	tests := []struct {
+		name       string
+		header     string
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{"untrusted remote ignores headers", xff, "203.0.113.5:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "203.0.113.5"},
+		{"trusted remote uses x-forwarded-for", xff, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "1.2.3.4"},
+		{"trusted hops are skipped", xff, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "6.6.6.6, 1.2.3.4, 192.168.1.1"}, "1.2.3.4"},
+		{"all hops trusted returns furthest", xff, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.1.1.1, 10.2.2.2"}, "10.1.1.1"},
+		{"no headers uses remote", xff, "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"client sent forwarded is ignored", xff, "10.0.0.1:1234", map[string]string{"Forwarded": "for=6.6.6.6", "X-Forwarded-For": "1.2.3.4"}, "1.2.3.4"},
+		{"client sent x-real-ip is ignored", xff, "10.0.0.1:1234", map[string]string{"X-Real-IP": "6.6.6.6"}, "10.0.0.1"},
+		{"forwarded", forwarded, "10.0.0.1:1234", map[string]string{"Forwarded": `for="[2001:db8:cafe::17]:4711";proto=https`, "X-Forwarded-For": "6.6.6.6"}, "2001:db8:cafe::17"},
+		{"forwarded unknown is skipped", forwarded, "10.0.0.1:1234", map[string]string{"Forwarded": "for=unknown, for=198.51.100.17"}, "198.51.100.17"},
+		{"client sent x-forwarded-for is ignored", forwarded, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "6.6.6.6"}, "10.0.0.1"},
+		{"x-real-ip", realIp, "10.0.0.1:1234", map[string]string{"X-Real-IP": "1.2.3.4", "X-Forwarded-For": "6.6.6.6"}, "1.2.3.4"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"}, test.header)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, _ := http.NewRequest("GET", "http://localhost/_search", nil)
+			req.RemoteAddr = test.remoteAddr
+
+			for key, value := range test.headers {
+				req.Header.Set(key, value)
+			}
+
+			if ip := trusted.ClientIp(req); ip != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, ip)
+			}
+		})
+	}
+}
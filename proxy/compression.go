@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"elasticsearch-proxy/config"
+	"elasticsearch-proxy/util"
+	"net/http"
+	"strings"
+)
+
+type compressionSettings struct {
+	enabled   bool
+	minSize   int64
+	encodings []string
+}
+
+func (t *MiddlewareTransport) SetCompression(compression config.CompressionConfig) {
+	t.compression.Store(compressionSettings{
+		enabled:   compression.Enabled,
+		minSize:   compression.ParseMinSize(),
+		encodings: compression.ParseEncodings(),
+	})
+}
+
+func (t *MiddlewareTransport) compressionSettings() compressionSettings {
+	settings, _ := t.compression.Load().(compressionSettings)
+
+	return settings
+}
+
+// Upstream responses in an encoding the client did not ask for are decoded, then when compression is enabled
+// the response is compressed with the preferred encoding the client accepts. Cached responses are stored
+// decoded so they always go through here
+func (t *MiddlewareTransport) negotiateEncoding(req *http.Request, resp *http.Response) error {
+	if req.Method == http.MethodHead || resp.Body == nil || resp.Body == http.NoBody ||
+		resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+
+	settings := t.compressionSettings()
+	acceptEncoding := req.Header.Get("Accept-Encoding")
+	encodings := util.ParseContentEncoding(resp.Header.Get("Content-Encoding"))
+
+	if settings.enabled {
+		addVary(resp.Header, "Accept-Encoding")
+	}
+
+	if len(encodings) > 0 {
+		if util.AcceptsEncodings(acceptEncoding, encodings) {
+			return nil
+		}
+
+		decoded, err := util.NewDecodingReadCloser(resp.Body, resp.Header.Get("Content-Encoding"))
+
+		// Nothing else can be done with it, the client gets the response as it is
+		if _, unsupported := err.(util.UnsupportedEncodingError); unsupported {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		resp.Body = decoded
+		resp.Header.Del("Content-Encoding")
+		setUnknownLength(resp)
+	}
+
+	if !settings.enabled || (resp.ContentLength >= 0 && resp.ContentLength < settings.minSize) {
+		return nil
+	}
+
+	encoding := util.NegotiateEncoding(acceptEncoding, settings.encodings)
+
+	if encoding == "" {
+		return nil
+	}
+
+	encoded, err := util.NewEncodingReadCloser(resp.Body, encoding)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body = encoded
+	resp.Header.Set("Content-Encoding", encoding)
+	setUnknownLength(resp)
+
+	return nil
+}
+
+func setUnknownLength(resp *http.Response) {
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+}
+
+func addVary(header http.Header, value string) {
+	for _, existing := range header.Values("Vary") {
+		for _, name := range strings.Split(existing, ",") {
+			if strings.EqualFold(strings.TrimSpace(name), value) {
+				return
+			}
+		}
+	}
+
+	header.Add("Vary", value)
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"elasticsearch-proxy/cache"
+	"elasticsearch-proxy/config"
+	"elasticsearch-proxy/util"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	response := `{"responses":[` + strings.Repeat(`{"hits":[]},`, 200) + `{}]}`
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Always brotli, like a misbehaving upstream
+		body, _ := util.EncodeBody([]byte(response), "br")
+
+		w.Header().Set("Content-Encoding", "br")
+		w.Write(body)
+	}))
+	defer upstream.Close()
+
+	captured := make(chan capturedBodies, 3)
+	transport := newTestTransport(captured, 1024*1024)
+	transport.Cache = cache.NewStorage()
+	transport.SetCacheTtl(time.Minute)
+	transport.SetCompression(config.CompressionConfig{Enabled: true, MinSize: "1KB"})
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		encoding       string
+		cached         bool
+	}{
+		{"upstream encoding is kept when accepted", "gzip, br", "br", false},
+		{"cached response is compressed for the client", "gzip", "gzip", true},
+		{"cached response is decoded for a client without compression", "identity", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", upstream.URL+"/properties/_msearch", strings.NewReader("{}"))
+			req.Header.Set("Accept-Encoding", test.acceptEncoding)
+
+			resp, err := transport.RoundTrip(req)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if encoding := resp.Header.Get("Content-Encoding"); encoding != test.encoding {
+				t.Errorf("expected encoding %q, got %q", test.encoding, encoding)
+			}
+
+			if cached := resp.Header.Get("X-Cached") != ""; cached != test.cached {
+				t.Errorf("expected cached %v", test.cached)
+			}
+
+			if vary := resp.Header.Get("Vary"); vary != "Accept-Encoding" {
+				t.Errorf("expected Vary: Accept-Encoding, got %q", vary)
+			}
+
+			decoded, err := util.DecodeBody(body, test.encoding, 0)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(decoded) != response {
+				t.Errorf("expected the original response, got %q", decoded)
+			}
+
+			if bodies := <-captured; bodies.response != response {
+				t.Errorf("expected the middleware to get the decoded response, got %q", bodies.response)
+			}
+
+			// The cache is written in the background
+			time.Sleep(10 * time.Millisecond)
+		})
+	}
+
+	t.Run("small responses are not compressed", func(t *testing.T) {
+		resp := &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{},
+			Body:          ioutil.NopCloser(strings.NewReader("{}")),
+			ContentLength: 2,
+		}
+		req, _ := http.NewRequest("GET", upstream.URL, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		if err := transport.negotiateEncoding(req, resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if encoding := resp.Header.Get("Content-Encoding"); encoding != "" {
+			t.Errorf("expected no encoding, got %q", encoding)
+		}
+	})
+}
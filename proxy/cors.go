@@ -0,0 +1,209 @@
+package proxy
+
+import (
+	"elasticsearch-proxy/config"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CorsPolicy answers preflight requests and adds the CORS headers to the actual responses of a route
+type CorsPolicy struct {
+	rules *corsRules
+
+	// The rules can be replaced while requests are being handled when the config is reloaded
+	mu sync.RWMutex
+}
+
+type corsRules struct {
+	allowAnyOrigin   bool
+	origins          map[string]bool
+	wildcardOrigins  []wildcardOrigin
+	originPatterns   []*regexp.Regexp
+	allowCredentials bool
+	allowAnyHeader   bool
+	allowedMethods   string
+	allowedHeaders   string
+	exposedHeaders   string
+	maxAge           string
+}
+
+// "https://*.example.com" matches any subdomain but not example.com itself
+type wildcardOrigin struct {
+	prefix string
+	suffix string
+}
+
+func (w wildcardOrigin) matches(origin string) bool {
+	return len(origin) > len(w.prefix)+len(w.suffix) &&
+		strings.HasPrefix(origin, w.prefix) &&
+		strings.HasSuffix(origin, w.suffix) &&
+		!strings.ContainsAny(origin[len(w.prefix):len(origin)-len(w.suffix)], "/:")
+}
+
+func NewCorsPolicy(cors config.CorsConfig) (*CorsPolicy, error) {
+	policy := &CorsPolicy{}
+
+	if err := policy.Replace(cors); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// Nothing is changed if the config is invalid
+func (p *CorsPolicy) Replace(cors config.CorsConfig) error {
+	if cors.AllowCredentials && cors.AllowsAnyOrigin() {
+		return fmt.Errorf("allowCredentials cannot be used when any origin is allowed")
+	}
+
+	rules := &corsRules{
+		origins:          map[string]bool{},
+		allowCredentials: cors.AllowCredentials,
+		allowedMethods:   strings.Join(cors.ParseAllowedMethods(), ", "),
+		exposedHeaders:   strings.Join(cors.ParseExposedHeaders(), ", "),
+	}
+
+	for _, origin := range cors.ParseAllowedOrigins() {
+		if origin == "*" {
+			rules.allowAnyOrigin = true
+		} else if i := strings.Index(origin, "://*."); i >= 0 {
+			rules.wildcardOrigins = append(rules.wildcardOrigins, wildcardOrigin{
+				prefix: strings.ToLower(origin[:i+3]),
+				suffix: strings.ToLower(origin[i+4:]),
+			})
+		} else {
+			rules.origins[strings.ToLower(origin)] = true
+		}
+	}
+
+	// Anchored so "https://.*\.example\.com" cannot match "https://x.example.com.attacker.net"
+	for _, pattern := range cors.AllowedOriginPatterns {
+		compiled, err := regexp.Compile("^(?:" + pattern + ")$")
+
+		if err != nil {
+			return fmt.Errorf("invalid CORS origin pattern %q: %v", pattern, err)
+		}
+
+		rules.originPatterns = append(rules.originPatterns, compiled)
+	}
+
+	allowedHeaders := cors.ParseAllowedHeaders()
+
+	for _, header := range allowedHeaders {
+		if header == "*" {
+			rules.allowAnyHeader = true
+		}
+	}
+
+	rules.allowedHeaders = strings.Join(allowedHeaders, ", ")
+
+	if maxAge := cors.ParseMaxAge(); maxAge > 0 {
+		rules.maxAge = strconv.Itoa(int(maxAge.Seconds()))
+	}
+
+	p.mu.Lock()
+	p.rules = rules
+	p.mu.Unlock()
+
+	return nil
+}
+
+func IsPreflightRequest(req *http.Request) bool {
+	return req.Method == http.MethodOptions && req.Header.Get("Origin") != "" && req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// Apply adds the headers for the request's origin, for a preflight request the response is complete after this
+func (p *CorsPolicy) Apply(res http.ResponseWriter, req *http.Request) {
+	p.mu.RLock()
+	rules := p.rules
+	p.mu.RUnlock()
+
+	headers := res.Header()
+	origin := req.Header.Get("Origin")
+	preflight := IsPreflightRequest(req)
+
+	// The allowed origin depends on the request's origin unless every origin gets "*"
+	if !rules.allowAnyOrigin || rules.allowCredentials {
+		addVary(headers, "Origin")
+	}
+
+	if preflight {
+		addVary(headers, "Access-Control-Request-Method")
+		addVary(headers, "Access-Control-Request-Headers")
+	}
+
+	if origin == "" || !rules.allowsOrigin(origin) {
+		return
+	}
+
+	if rules.allowAnyOrigin && !rules.allowCredentials {
+		headers.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		headers.Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if rules.allowCredentials {
+		headers.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !preflight {
+		if rules.exposedHeaders != "" {
+			headers.Set("Access-Control-Expose-Headers", rules.exposedHeaders)
+		}
+
+		return
+	}
+
+	headers.Set("Access-Control-Allow-Methods", rules.allowedMethods)
+
+	if rules.allowAnyHeader {
+		if requested := req.Header.Get("Access-Control-Request-Headers"); requested != "" {
+			headers.Set("Access-Control-Allow-Headers", requested)
+		}
+	} else {
+		headers.Set("Access-Control-Allow-Headers", rules.allowedHeaders)
+	}
+
+	if rules.maxAge != "" {
+		headers.Set("Access-Control-Max-Age", rules.maxAge)
+	}
+}
+
+func (rules *corsRules) allowsOrigin(origin string) bool {
+	if rules.allowAnyOrigin {
+		return true
+	}
+
+	lowered := strings.ToLower(origin)
+
+	if rules.origins[lowered] {
+		return true
+	}
+
+	for _, wildcard := range rules.wildcardOrigins {
+		if wildcard.matches(lowered) {
+			return true
+		}
+	}
+
+	for _, pattern := range rules.originPatterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The upstream's own CORS headers would be added alongside ours, only the route's policy should apply
+func removeUpstreamCorsHeaders(resp *http.Response) {
+	for name := range resp.Header {
+		if strings.HasPrefix(name, "Access-Control-") {
+			resp.Header.Del(name)
+		}
+	}
+}
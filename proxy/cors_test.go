@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"elasticsearch-proxy/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCorsPolicy(t *testing.T) {
+	restricted := config.CorsConfig{
+		AllowedOrigins:        []string{"https://www.example.com", "https://*.rentivo.com"},
+		AllowedOriginPatterns: []string{`^https://[a-z]+\.example\.org$`, `https://.*\.example\.net`},
+		AllowCredentials:      true,
+		AllowedMethods:        []string{"GET", "POST"},
+		AllowedHeaders:        []string{"Content-Type", "X-App"},
+		ExposedHeaders:        []string{"X-Cached"},
+		MaxAge:                "10m",
+	}
+
+	tests := []struct {
+		name      string
+		cors      config.CorsConfig
+		method    string
+		origin    string
+		requested string
+		expected  map[string]string
+	}{
+		{
+			name:     "any origin by default",
+			method:   "GET",
+			origin:   "https://anywhere.com",
+			expected: map[string]string{"Access-Control-Allow-Origin": "*", "Vary": "", "Access-Control-Expose-Headers": "X-Request-Id, traceparent"},
+		},
+		{
+			name:   "default preflight",
+			method: "OPTIONS",
+			origin: "https://anywhere.com",
+			expected: map[string]string{
+				"Access-Control-Allow-Origin":  "*",
+				"Access-Control-Allow-Methods": "GET, POST, PUT, DELETE, PATCH, OPTIONS",
+				"Access-Control-Max-Age":       "",
+			},
+		},
+		{
+			name:   "exact origin is echoed with credentials",
+			cors:   restricted,
+			method: "GET",
+			origin: "https://www.example.com",
+			expected: map[string]string{
+				"Access-Control-Allow-Origin":      "https://www.example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Expose-Headers":    "X-Cached",
+				"Access-Control-Allow-Methods":     "",
+				"Vary":                             "Origin",
+			},
+		},
+		{
+			name:     "wildcard subdomain",
+			cors:     restricted,
+			method:   "GET",
+			origin:   "https://app.rentivo.com",
+			expected: map[string]string{"Access-Control-Allow-Origin": "https://app.rentivo.com"},
+		},
+		{
+			name:     "wildcard does not match the bare domain",
+			cors:     restricted,
+			method:   "GET",
+			origin:   "https://rentivo.com",
+			expected: map[string]string{"Access-Control-Allow-Origin": "", "Vary": "Origin"},
+		},
+		{
+			name:     "pattern",
+			cors:     restricted,
+			method:   "GET",
+			origin:   "https://search.example.org",
+			expected: map[string]string{"Access-Control-Allow-Origin": "https://search.example.org"},
+		},
+		{
+			name:     "patterns are anchored",
+			cors:     restricted,
+			method:   "GET",
+			origin:   "https://x.example.net.attacker.com",
+			expected: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:     "unanchored pattern",
+			cors:     restricted,
+			method:   "GET",
+			origin:   "https://x.example.net",
+			expected: map[string]string{"Access-Control-Allow-Origin": "https://x.example.net"},
+		},
+		{
+			name:     "unknown origin",
+			cors:     restricted,
+			method:   "GET",
+			origin:   "https://evil.com",
+			expected: map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Credentials": ""},
+		},
+		{
+			name:      "restricted preflight",
+			cors:      restricted,
+			method:    "OPTIONS",
+			origin:    "https://www.example.com",
+			requested: "content-type",
+			expected: map[string]string{
+				"Access-Control-Allow-Origin":   "https://www.example.com",
+				"Access-Control-Allow-Methods":  "GET, POST",
+				"Access-Control-Allow-Headers":  "Content-Type, X-App",
+				"Access-Control-Max-Age":        "600",
+				"Access-Control-Expose-Headers": "",
+			},
+		},
+		{
+			name:      "any header is echoed",
+			cors:      config.CorsConfig{AllowedHeaders: []string{"*"}},
+			method:    "OPTIONS",
+			origin:    "https://www.example.com",
+			requested: "x-custom",
+			expected:  map[string]string{"Access-Control-Allow-Headers": "x-custom"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy, err := NewCorsPolicy(test.cors)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(test.method, "/properties/_search", nil)
+			req.Header.Set("Origin", test.origin)
+
+			if test.method == "OPTIONS" {
+				req.Header.Set("Access-Control-Request-Method", "POST")
+				req.Header.Set("Access-Control-Request-Headers", test.requested)
+			}
+
+			res := httptest.NewRecorder()
+			policy.Apply(res, req)
+
+			for name, expected := range test.expected {
+				if value := res.Header().Get(name); value != expected {
+					t.Errorf("expected %s %q, got %q", name, expected, value)
+				}
+			}
+		})
+	}
+}
+
+func TestCorsPolicyRejectsCredentialsForAnyOrigin(t *testing.T) {
+	tests := []struct {
+		name string
+		cors config.CorsConfig
+	}{
+		{"explicit", config.CorsConfig{AllowedOrigins: []string{"https://www.example.com", "*"}, AllowCredentials: true}},
+		{"default", config.CorsConfig{AllowCredentials: true}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewCorsPolicy(test.cors); err == nil {
+				t.Error("expected credentials with any origin to be rejected")
+			}
+		})
+	}
+}
+
+func TestRemoveUpstreamCorsHeaders(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set("Access-Control-Allow-Origin", "*")
+	resp.Header.Set("Content-Type", "application/json")
+
+	removeUpstreamCorsHeaders(resp)
+
+	if resp.Header.Get("Access-Control-Allow-Origin") != "" || resp.Header.Get("Content-Type") == "" {
+		t.Errorf("expected only the CORS headers to be removed, got %v", resp.Header)
+	}
+}
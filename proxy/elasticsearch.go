@@ -3,27 +3,31 @@ package proxy
 import (
 	"elasticsearch-proxy/cache"
 	"elasticsearch-proxy/elasticsearch"
-	"elasticsearch-proxy/util"
 	"fmt"
 	"github.com/apex/log"
 	"github.com/samvaughton/crawlerdetection"
 	"github.com/tidwall/gjson"
-	"net"
 	"net/http"
 	"net/url"
 	"strings"
 )
 
 func NewElasticsearchReverseProxyHandler(ctx *ReverseProxyHandlerContext) ReverseProxyHandler {
-	ctx.Proxy.Transport = &MiddlewareTransport{
-		http.DefaultTransport,
-		cache.NewStorage(),
-		ctx,
-		ProcessElasticRequest,
+	transport := &MiddlewareTransport{
+		RoundTripper:               ctx.Proxy.Transport,
+		Cache:                      cache.NewStorage(),
+		ReverseProxyHandlerContext: ctx,
+		MiddlewareRoutine:          ProcessElasticRequest,
 	}
+	hostConfig := ctx.Config.Proxy.Elasticsearch
+	transport.SetCacheTtl(hostConfig.ParseCacheTtl())
+	transport.SetBodyLimits(hostConfig.ParseMaxRequestBodySize(), hostConfig.ParseMaxCaptureSize(), hostConfig.ParseMaxDecodedSize())
+	transport.SetCompression(hostConfig.Compression)
+
+	ctx.Proxy.Transport = transport
 
 	// Crawler check
-	ctx.LoggingFilters.AddFilter(func(req *http.Request, fields log.Fields) bool {
+	ctx.LoggingFilters.AddFilter("crawler", func(req *http.Request, fields log.Fields) bool {
 		if req.Header.Get("Debug") != "" {
 			return true
 		}
@@ -31,47 +35,28 @@ func NewElasticsearchReverseProxyHandler(ctx *ReverseProxyHandlerContext) Revers
 		userAgent := req.Header.Get("User-Agent")
 
 		if userAgent == "" {
-			log.WithField("userAgent", userAgent).Debug("No User-Agent provided, skipping")
+			RequestLog(req).WithField("userAgent", userAgent).Debug("No User-Agent provided, skipping")
 			return false // Do not accept search req's with no user agent
 		}
 
 		if crawlerdetection.IsCrawler(userAgent) {
-			log.WithField("userAgent", userAgent).Debug("Crawler detected, skipping")
+			RequestLog(req).WithField("userAgent", userAgent).Debug("Crawler detected, skipping")
 			return false
 		}
 
 		return true
 	})
 
-	ctx.LoggingFilters.AddFilter(func(req *http.Request, fields log.Fields) bool {
+	ctx.LoggingFilters.AddFilter("has-metrics", func(req *http.Request, fields log.Fields) bool {
 		metrics := fields.Get("data").(map[string]interface{})
 
 		return len(metrics) > 0
 	})
 
-	ctx.LoggingFilters.AddFilter(func(req *http.Request, fields log.Fields) bool {
-		index := fields.Get("index").(string)
-		metrics := fields.Get("data").(map[string]interface{})
-
-		if strings.Contains(index, "dormoa") && len(metrics) < 3 {
-			return false
-		}
-
-		if len(metrics) == 1 || (len(metrics) == 2 && metrics[elasticsearch.MetricResponse] != nil)  {
-
-			// If there is a single nightly metric that is the default range then we can not log this search request
-			if metric, exists := elasticsearch.FindMetricByName(elasticsearch.MetricNightlyLowPrice, metrics); exists {
-				nightlyLowMetric := metric.(elasticsearch.MetricRangeData)
-
-				if nightlyLowMetric.Minimum == 0 && nightlyLowMetric.Maximum == 9999 {
-					return false
-				}
-			}
-
-		}
-
-		return true
-	})
+	// Business rules (eg. which indexes need more metrics) are configured with proxy.elasticsearch.filters
+	if err := ctx.LoggingFilters.AddRules(ctx.Config.Proxy.Elasticsearch.Filters); err != nil {
+		panic(err)
+	}
 
 	return NewBasicReverseProxyHandler(ctx)
 }
@@ -87,43 +72,87 @@ func ProcessElasticRequest(ctx ReverseProxyHandlerContext, req *http.Request, re
 
 	if requestType != RequestElasticsearch {
 		fields := GenerateDefaultFields(requestType, requestedUrl, req)
-		util.LogData(&fields)
+		ctx.LogGeneric(fields)
 
 		return
 	}
 
-	parsedQueryLines := elasticsearch.ParseQueries(decodedRequestBody)
+	parsedQueryLines := elasticsearch.ParseQueryLines(decodedRequestBody)
 
 	deDuplicatedResponseLines := elasticsearch.DeDuplicateJsonLines(gjson.Parse(decodedResponseBody).Get("responses").Array())
 
-	for index, query := range elasticsearch.DeDuplicateJsonLines(parsedQueryLines) {
+	for index, queryLine := range elasticsearch.DeDuplicateJsonLines(parsedQueryLines) {
 		var queryResponse gjson.Result
 		if index >= 0 && index < len(deDuplicatedResponseLines) {
 			queryResponse = deDuplicatedResponseLines[index]
 		} else {
 			// no response found for the query line.. this should not happen very often at all.
-			log.Errorf("ES: Could not match response line to query line", parsedQueryLines, deDuplicatedResponseLines)
+			RequestLog(req).Errorf("ES: Could not match response line to query line: %v %v", parsedQueryLines, deDuplicatedResponseLines)
+
+			continue
+		}
+
+		// Single record lookups are logged separately so they do not show up as searches
+		if view, isView := elasticsearch.ExtractPropertyView(queryLine); isView {
+			ProcessPropertyView(ctx, req, requestedUrl, queryLine, queryResponse, view)
 
 			continue
 		}
 
-		fields := GenerateElasticsearchQueryFields(requestType, requestedUrl, req, query, queryResponse)
+		fields := GenerateElasticsearchQueryFields(requestType, requestedUrl, req, queryLine, queryResponse)
 
 		if ctx.LoggingFilters.Process(req, fields) == false {
-			log.Debug("Query did not match the provided filters")
+			RequestLog(req).Debug("Query did not match the provided filters")
 
 			continue
 		}
 
-		// Since this is the elasticsearch queries, we want to de-bounce which is handled by the queue
-		ctx.Queue.Channel <- QueueLogEntry{
-			Key:    fmt.Sprintf("%s", fields.Get("ip")),
-			Fields: fields,
+		// Added after filtering as the filters rely on the number of query metrics
+		metrics := fields.Get("data").(map[string]interface{})
+		elasticsearch.ExtractPostFilterMetrics(queryLine, metrics)
+
+		fields["zeroResults"] = IsZeroResultSearch(fields)
+
+		if ctx.Config != nil && ctx.Config.Logging.SearchResults.Enabled {
+			elasticsearch.ExtractQueryResultMetrics(queryLine, queryResponse, ctx.Config.Logging.SearchResults.MaxHits, metrics)
+		}
+
+		topBuckets := 0
+		if ctx.Config != nil {
+			topBuckets = ctx.Config.Logging.Aggregations.TopBuckets
 		}
+
+		elasticsearch.ExtractAggregationMetrics(queryLine, queryResponse, topBuckets, metrics)
+
+		// Since this is the elasticsearch queries, we want to de-bounce which is handled by the queue
+		ctx.Enqueue(ctx.Queue, req, fmt.Sprintf("%s", fields.Get("ip")), fields)
 	}
 }
 
-func GenerateElasticsearchQueryFields(requestType int, requestedUrl string, req *http.Request, actualQuery gjson.Result, queryResponse gjson.Result) log.Fields {
+func ProcessPropertyView(ctx ReverseProxyHandlerContext, req *http.Request, requestedUrl string, queryLine gjson.Result, queryResponse gjson.Result, view elasticsearch.MetricPropertyViewData) {
+	if ctx.PropertyViewQueue == nil {
+		RequestLog(req).WithField("propertyId", view.PropertyId).Debug("Property view detected but no property view index is configured")
+
+		return
+	}
+
+	fields := GenerateElasticsearchQueryFields(RequestPropertyView, requestedUrl, req, queryLine, queryResponse)
+	fields["propertyId"] = view.PropertyId
+	fields.Get("data").(map[string]interface{})[elasticsearch.MetricPropertyView] = view
+
+	if ctx.LoggingFilters.Process(req, fields) == false {
+		RequestLog(req).Debug("Property view did not match the provided filters")
+
+		return
+	}
+
+	// De-bounce per visitor and property, otherwise viewing several properties in a row would only log the last
+	ctx.Enqueue(ctx.PropertyViewQueue, req, fmt.Sprintf("%s:%s", fields.Get("ip"), view.PropertyId), fields)
+}
+
+func GenerateElasticsearchQueryFields(requestType int, requestedUrl string, req *http.Request, queryLine gjson.Result, queryResponse gjson.Result) log.Fields {
+	actualQuery := elasticsearch.ParseActualQuery(queryLine)
+
 	// Since we know this is an ES query, we can extract the index
 	indexName := ""
 	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
@@ -132,11 +161,7 @@ func GenerateElasticsearchQueryFields(requestType int, requestedUrl string, req
 		indexName = parts[0]
 	}
 
-	ip, _, err := net.SplitHostPort(req.RemoteAddr)
-
-	if err != nil {
-		log.Error("Failed to split the host/port on remote address: " + req.RemoteAddr)
-	}
+	ip := GetClientIp(req)
 
 	parsedOrigin, err := url.Parse(req.Header.Get("Origin"))
 	host := ""
@@ -144,6 +169,8 @@ func GenerateElasticsearchQueryFields(requestType int, requestedUrl string, req
 		host = parsedOrigin.Host
 	}
 
+	metrics := elasticsearch.ExtractQueryMetrics(actualQuery, queryResponse)
+
 	return log.Fields{
 		"type":     GetRequestTypeString(requestType),
 		"url":      requestedUrl,
@@ -152,7 +179,9 @@ func GenerateElasticsearchQueryFields(requestType int, requestedUrl string, req
 		"ip":       ip,
 		"index":    indexName,
 		"userAgent": req.Header.Get("User-Agent"),
+		"requestId": GetTraceContext(req).RequestId,
+		"traceId":   GetTraceContext(req).TraceId,
 		"rawQuery": actualQuery.String(),
-		"data":     elasticsearch.ExtractQueryMetrics(actualQuery, queryResponse),
+		"data":     metrics,
 	}
 }
\ No newline at end of file
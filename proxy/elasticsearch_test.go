@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"elasticsearch-proxy/elasticsearch"
+	"github.com/apex/log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestProcessElasticRequestPostFilterMetrics(t *testing.T) {
+	target, _ := url.Parse("http://localhost:9200")
+	queue := NewQueue(time.Second, log.Logger{})
+	ctx := NewReverseProxyHandlerContext(target, NewSingleHostReverseProxy(target, nil), &queue)
+
+	filteredMetrics := -1
+	ctx.LoggingFilters.AddFilter("metric-count", func(req *http.Request, fields log.Fields) bool {
+		filteredMetrics = len(fields.Get("data").(map[string]interface{}))
+		return true
+	})
+
+	body := `{"index":"properties"}
+{"query":{"bool":{"must":[{"range":{"listing.bedrooms":{"gte":4,"lte":5}}}]}},"post_filter":{"bool":{"must":[{"range":{"listing.bedrooms":{"gte":2,"lte":3}}},{"range":{"listing.bathrooms":{"gte":1}}}]}}}
+`
+	response := `{"responses":[{"hits":{"total":{"value":10},"hits":[]}}]}`
+
+	req := httptest.NewRequest("POST", "/properties/_msearch", nil)
+	ProcessElasticRequest(ctx, req, nil, body, response)
+
+	entry := <-queue.Channel
+	metrics := entry.Fields.Get("data").(map[string]interface{})
+
+	// Only the query section (bedrooms and the response) is seen by the filters
+	if filteredMetrics != 2 {
+		t.Errorf("expected the filters to see 2 metrics, got %d", filteredMetrics)
+	}
+
+	if bedrooms := metrics[elasticsearch.MetricBedrooms].(elasticsearch.MetricRangeData); bedrooms.Minimum != 4 || bedrooms.Maximum != 5 {
+		t.Errorf("expected the query bedrooms to be kept, got %+v", bedrooms)
+	}
+
+	if _, exists := metrics[elasticsearch.MetricBathrooms]; !exists {
+		t.Errorf("expected the post_filter bathrooms to be added, got %v", metrics)
+	}
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"elasticsearch-proxy/geo"
+	"elasticsearch-proxy/useragent"
+	"github.com/apex/log"
+	"net/http"
+)
+
+/*
+ * Enrichers add additional fields to an entry once it has passed the filters and before it is sent to the queue,
+ * eg. looking up the location of the client IP
+ */
+
+type EnrichmentProcessor struct {
+	Enrichers []func(req *http.Request, fields log.Fields)
+}
+
+func NewEnrichmentProcessor() EnrichmentProcessor {
+	return EnrichmentProcessor{
+		Enrichers: make([]func(req *http.Request, fields log.Fields), 0),
+	}
+}
+
+func (ep *EnrichmentProcessor) AddEnricher(enricher func(req *http.Request, fields log.Fields)) {
+	ep.Enrichers = append(ep.Enrichers, enricher)
+}
+
+func (ep *EnrichmentProcessor) Process(req *http.Request, fields log.Fields) {
+	for _, enricher := range ep.Enrichers {
+		enricher(req, fields)
+	}
+}
+
+func NewGeoEnricher(db *geo.Database) func(req *http.Request, fields log.Fields) {
+	return func(req *http.Request, fields log.Fields) {
+		ip, _ := fields.Get("ip").(string)
+
+		if location, found := db.Lookup(ip); found {
+			fields["geo"] = location
+		}
+	}
+}
+
+func NewUserAgentEnricher(parser *useragent.Parser) func(req *http.Request, fields log.Fields) {
+	return func(req *http.Request, fields log.Fields) {
+		userAgent, _ := fields.Get("userAgent").(string)
+
+		if userAgent != "" {
+			fields["ua"] = parser.Parse(userAgent)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"elasticsearch-proxy/config"
+	"encoding/json"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/tidwall/gjson"
+	"net/http"
+	"regexp"
+)
+
+/*
+ * Filter rules are the configurable version of the LoggingFilters, each rule is compiled into a regular filter
+ * so product can change what gets logged without a deploy
+ */
+
+type fieldMatcher struct {
+	Field string
+	Regex *regexp.Regexp
+}
+
+func (fp *FilterProcessor) AddRules(rules []config.FilterRuleConfig) error {
+	filters, err := CompileFilterRules(rules)
+
+	if err != nil {
+		return err
+	}
+
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	fp.Filters = append(fp.Filters, filters...)
+
+	return nil
+}
+
+// Swaps out the rule based filters leaving the built-in ones in place, nothing is changed if a rule is invalid.
+// Rules keep their rejection counts (see /_zazu/filters) as long as their name is unchanged
+func (fp *FilterProcessor) ReplaceRules(rules []config.FilterRuleConfig) error {
+	filters, err := CompileFilterRules(rules)
+
+	if err != nil {
+		return err
+	}
+
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	replaced := make([]*Filter, 0, len(fp.Filters))
+	rejections := make(map[string]uint64)
+
+	for _, filter := range fp.Filters {
+		if filter.FromRule {
+			rejections[filter.Name] = filter.Rejections()
+		} else {
+			replaced = append(replaced, filter)
+		}
+	}
+
+	for _, filter := range filters {
+		filter.rejections = rejections[filter.Name]
+	}
+
+	fp.Filters = append(replaced, filters...)
+
+	return nil
+}
+
+func CompileFilterRules(rules []config.FilterRuleConfig) ([]*Filter, error) {
+	filters := make([]*Filter, 0, len(rules))
+
+	for i, rule := range rules {
+		filter, err := CompileFilterRule(rule)
+
+		if err != nil {
+			return nil, err
+		}
+
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("rule-%d", i+1)
+		}
+
+		filters = append(filters, &Filter{
+			Name:     name,
+			Fn:       filter,
+			FromRule: true,
+		})
+	}
+
+	return filters, nil
+}
+
+func CompileFilterRule(rule config.FilterRuleConfig) (func(req *http.Request, fields log.Fields) bool, error) {
+	if rule.Action != config.FilterActionInclude && rule.Action != config.FilterActionExclude {
+		return nil, fmt.Errorf("filter rule %q: action must be %q or %q", rule.Name, config.FilterActionInclude, config.FilterActionExclude)
+	}
+
+	matches, err := CompileFilterMatch(rule.Match)
+
+	if err != nil {
+		return nil, fmt.Errorf("filter rule %q: %v", rule.Name, err)
+	}
+
+	return func(req *http.Request, fields log.Fields) bool {
+		matched := matches(fields)
+
+		if rule.Action == config.FilterActionExclude {
+			return !matched
+		}
+
+		return matched
+	}, nil
+}
+
+func CompileFilterMatch(match config.FilterMatchConfig) (func(fields log.Fields) bool, error) {
+	var matchers []fieldMatcher
+
+	for field, pattern := range map[string]string{
+		"type":      match.Type,
+		"index":     match.Index,
+		"host":      match.Host,
+		"app":       match.App,
+		"userAgent": match.UserAgent,
+	} {
+		if pattern == "" {
+			continue
+		}
+
+		regex, err := regexp.Compile(pattern)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s pattern %q: %v", field, pattern, err)
+		}
+
+		matchers = append(matchers, fieldMatcher{Field: field, Regex: regex})
+	}
+
+	networks, err := ParseIpNetworks(match.IpCidrs)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid ip cidr: %v", err)
+	}
+
+	ignored := make(map[string]bool)
+	for _, name := range match.MetricCountIgnore {
+		ignored[name] = true
+	}
+
+	return func(fields log.Fields) bool {
+		for _, matcher := range matchers {
+			value, _ := fields.Get(matcher.Field).(string)
+
+			if !matcher.Regex.MatchString(value) {
+				return false
+			}
+		}
+
+		if len(networks) > 0 {
+			ip, _ := fields.Get("ip").(string)
+
+			if !networks.Contains(ip) {
+				return false
+			}
+		}
+
+		metrics, _ := fields.Get("data").(map[string]interface{})
+
+		if match.MetricCount != nil {
+			count := 0
+			for name := range metrics {
+				if !ignored[name] {
+					count++
+				}
+			}
+
+			if !match.MetricCount.Matches(float64(count)) {
+				return false
+			}
+		}
+
+		for _, condition := range match.Metrics {
+			if !MatchMetricCondition(condition, metrics) {
+				return false
+			}
+		}
+
+		return true
+	}, nil
+}
+
+func MatchMetricCondition(condition config.MetricConditionConfig, metrics map[string]interface{}) bool {
+	metric, exists := metrics[condition.Name]
+
+	if condition.Present != nil && *condition.Present != exists {
+		return false
+	}
+
+	if condition.Equals == nil && condition.Value == nil {
+		return true
+	}
+
+	if !exists {
+		return false
+	}
+
+	// Metrics are typed structs, going via JSON lets the path use the same names as the logged document
+	encoded, err := json.Marshal(metric)
+
+	if err != nil {
+		return false
+	}
+
+	value := gjson.GetBytes(encoded, condition.Path)
+
+	if !value.Exists() {
+		return false
+	}
+
+	if condition.Equals != nil && value.String() != *condition.Equals {
+		return false
+	}
+
+	if condition.Value != nil && !condition.Value.Matches(value.Float()) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"elasticsearch-proxy/config"
+	"elasticsearch-proxy/elasticsearch"
+	"github.com/apex/log"
+	"gopkg.in/yaml.v2"
+	"testing"
+)
+
+const testFilterRules = `
+- name: "dormoa-minimum-metrics"
+  action: "exclude"
+  match:
+    index: "dormoa"
+    metricCount:
+      lt: 3
+- name: "default-nightly-low-only"
+  action: "exclude"
+  match:
+    metricCount:
+      equals: 1
+    metricCountIgnore: ["response"]
+    metrics:
+      - name: "nightlyLow"
+        path: "maximum"
+        value:
+          equals: 9999
+- name: "office-only"
+  action: "include"
+  match:
+    ipCidrs: ["10.0.0.0/8"]
+`
+
+func TestFilterRules(t *testing.T) {
+	var rules []config.FilterRuleConfig
+
+	if err := yaml.Unmarshal([]byte(testFilterRules), &rules); err != nil {
+		t.Fatal(err)
+	}
+
+	fp := NewFilterProcessor()
+
+	if err := fp.AddRules(rules); err != nil {
+		t.Fatal(err)
+	}
+
+	defaultNightlyLow := elasticsearch.MetricRangeData{Minimum: 0, Maximum: 9999}
+	bedrooms := elasticsearch.MetricRangeData{Minimum: 2, Maximum: 3}
+	response := elasticsearch.MetricResponseData{ResultCount: 10}
+
+	tests := []struct {
+		name     string
+		fields   log.Fields
+		expected bool
+	}{
+		{
+			"dormoa with too few metrics is excluded",
+			log.Fields{"index": "dormoa-listings", "ip": "10.0.0.1", "data": map[string]interface{}{"bedrooms": bedrooms, "response": response}},
+			false,
+		},
+		{
+			"other index with few metrics is kept",
+			log.Fields{"index": "listings", "ip": "10.0.0.1", "data": map[string]interface{}{"bedrooms": bedrooms, "response": response}},
+			true,
+		},
+		{
+			"default nightly low alone is excluded",
+			log.Fields{"index": "listings", "ip": "10.0.0.1", "data": map[string]interface{}{"nightlyLow": defaultNightlyLow, "response": response}},
+			false,
+		},
+		{
+			"default nightly low with other metrics is kept",
+			log.Fields{"index": "listings", "ip": "10.0.0.1", "data": map[string]interface{}{"nightlyLow": defaultNightlyLow, "bedrooms": bedrooms}},
+			true,
+		},
+		{
+			"include rule drops non matching ip",
+			log.Fields{"index": "listings", "ip": "203.0.113.5", "data": map[string]interface{}{"bedrooms": bedrooms, "guests": bedrooms}},
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := fp.Process(nil, test.fields); result != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+
+	t.Run("rejections are counted per filter", func(t *testing.T) {
+		stats := fp.Stats()
+
+		if len(stats) != 3 || stats[0].Name != "dormoa-minimum-metrics" {
+			t.Fatalf("unexpected stats: %+v", stats)
+		}
+
+		if stats[0].Rejections != 1 || stats[1].Rejections != 1 || stats[2].Rejections != 1 {
+			t.Errorf("unexpected rejection counts: %+v", stats)
+		}
+	})
+
+	t.Run("reloading keeps the counts of unchanged rules", func(t *testing.T) {
+		if err := fp.ReplaceRules(append(rules[:1:1], config.FilterRuleConfig{Name: "renamed", Action: config.FilterActionExclude})); err != nil {
+			t.Fatal(err)
+		}
+
+		stats := fp.Stats()
+
+		if len(stats) != 2 || stats[0].Rejections != 1 || stats[1].Name != "renamed" || stats[1].Rejections != 0 {
+			t.Errorf("unexpected stats after reload: %+v", stats)
+		}
+	})
+
+	t.Run("invalid action is rejected", func(t *testing.T) {
+		if _, err := CompileFilterRule(config.FilterRuleConfig{Name: "bad", Action: "drop"}); err == nil {
+			t.Fail()
+		}
+	})
+}
+
+// The defaults replace the rules that used to be hard-coded for the Elasticsearch route
+func TestDefaultElasticsearchFilters(t *testing.T) {
+	fp := NewFilterProcessor()
+
+	if err := fp.AddRules(config.DefaultElasticsearchFilters()); err != nil {
+		t.Fatal(err)
+	}
+
+	search := GetRequestTypeString(RequestElasticsearch)
+	defaultNightlyLow := elasticsearch.MetricRangeData{Minimum: 0, Maximum: 9999}
+	bedrooms := elasticsearch.MetricRangeData{Minimum: 2, Maximum: 3}
+	response := elasticsearch.MetricResponseData{ResultCount: 10}
+
+	tests := []struct {
+		name     string
+		fields   log.Fields
+		expected bool
+	}{
+		{
+			"dormoa with too few metrics is excluded",
+			log.Fields{"type": search, "index": "dormoa", "data": map[string]interface{}{"bedrooms": bedrooms, "response": response}},
+			false,
+		},
+		{
+			"dormoa with enough metrics is kept",
+			log.Fields{"type": search, "index": "dormoa", "data": map[string]interface{}{"bedrooms": bedrooms, "guests": bedrooms, "response": response}},
+			true,
+		},
+		{
+			"default nightly low alone is excluded",
+			log.Fields{"type": search, "index": "listings", "data": map[string]interface{}{"nightlyLow": defaultNightlyLow, "response": response}},
+			false,
+		},
+		{
+			"narrowed nightly low is kept",
+			log.Fields{"type": search, "index": "listings", "data": map[string]interface{}{"nightlyLow": elasticsearch.MetricRangeData{Minimum: 50, Maximum: 9999}}},
+			true,
+		},
+		{
+			"property views are not affected",
+			log.Fields{"type": GetRequestTypeString(RequestPropertyView), "index": "dormoa", "data": map[string]interface{}{"propertyView": "abc-123"}},
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := fp.Process(nil, test.fields); result != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/apex/log"
+	"math/rand"
+	"net/http"
+)
+
+// Sends a fraction of the rejected entries to the debug index along with the name of the filter that
+// rejected them, this lets us audit whether the filters are dropping searches they should not be
+func NewRejectionSampler(sampleRate float64, logger *log.Logger, privacy *PrivacyProcessor) func(filterName string, req *http.Request, fields log.Fields) {
+	return func(filterName string, req *http.Request, fields log.Fields) {
+		if sampleRate <= 0 || rand.Float64() >= sampleRate {
+			return
+		}
+
+		// Rejected entries are never queued so they have not been through the privacy processing yet
+		if privacy != nil {
+			privacy.Process(fields)
+		}
+
+		fields["rejectedBy"] = filterName
+
+		logger.WithFields(fields).Info(fmt.Sprintf("%v", fields.Get("url")))
+	}
+}
+
+func NewFilterStatsHandler(processors map[string]*FilterProcessor) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		stats := make(map[string][]FilterStats)
+
+		for name, processor := range processors {
+			stats[name] = processor.Stats()
+		}
+
+		WriteJsonResponse(res, stats)
+	}
+}
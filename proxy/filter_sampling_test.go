@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"elasticsearch-proxy/config"
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRejectionSampler(t *testing.T) {
+	newFields := func() log.Fields {
+		return log.Fields{"ip": "203.0.113.7", "url": "/properties/_search?q=john.smith@example.com"}
+	}
+
+	tests := []struct {
+		name       string
+		sampleRate float64
+		expected   int
+	}{
+		{"nothing is sampled at 0", 0, 0},
+		{"everything is sampled at 1", 1, 50},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := memory.New()
+			fp := NewFilterProcessor()
+			fp.AddFilter("reject-all", func(req *http.Request, fields log.Fields) bool { return false })
+			fp.OnReject = NewRejectionSampler(test.sampleRate, &log.Logger{Handler: handler, Level: log.InfoLevel}, nil)
+
+			for i := 0; i < 50; i++ {
+				fp.Process(httptest.NewRequest("GET", "/", nil), newFields())
+			}
+
+			if len(handler.Entries) != test.expected {
+				t.Fatalf("expected %d sampled entries, got %d", test.expected, len(handler.Entries))
+			}
+
+			for _, entry := range handler.Entries {
+				if entry.Fields.Get("rejectedBy") != "reject-all" || entry.Fields.Get("ip") != "203.0.113.7" {
+					t.Errorf("expected the raw entry rejected by reject-all, got %v", entry.Fields)
+				}
+			}
+		})
+	}
+
+	t.Run("the privacy settings are applied to sampled entries", func(t *testing.T) {
+		handler := memory.New()
+		privacy := NewPrivacyProcessor(config.PrivacyConfig{IpMode: config.IpModeTruncate, ScrubPii: true})
+		sample := NewRejectionSampler(1, &log.Logger{Handler: handler, Level: log.InfoLevel}, privacy)
+
+		sample("no-bots", httptest.NewRequest("GET", "/", nil), newFields())
+
+		if len(handler.Entries) != 1 {
+			t.Fatalf("expected one sampled entry, got %d", len(handler.Entries))
+		}
+
+		fields := handler.Entries[0].Fields
+
+		if fields.Get("ip") != "203.0.113.0" || fields.Get("url") != "/properties/_search?q=%5Bemail%5D" || fields.Get("rejectedBy") != "no-bots" {
+			t.Errorf("expected a truncated ip and scrubbed url, got %v", fields)
+		}
+	})
+}
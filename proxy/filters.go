@@ -3,6 +3,8 @@ package proxy
 import (
 	"github.com/apex/log"
 	"net/http"
+	"sync"
+	"sync/atomic"
 )
 
 /*
@@ -10,27 +12,82 @@ import (
  * whereas some might match a host or an IP and be filtered out
  */
 
+type Filter struct {
+	Name       string
+	Fn         func(req *http.Request, fields log.Fields) bool
+	FromRule   bool
+	rejections uint64
+}
+
+func (f *Filter) Rejections() uint64 {
+	return atomic.LoadUint64(&f.rejections)
+}
+
+type FilterStats struct {
+	Name       string `json:"name"`
+	Rejections uint64 `json:"rejections"`
+}
+
 type FilterProcessor struct {
-	Filters []func(req *http.Request, fields log.Fields) bool
+	Filters []*Filter
+
+	// Optional, called with the name of the filter that rejected the entry
+	OnReject func(filterName string, req *http.Request, fields log.Fields)
+
+	// Filters can be swapped while requests are being processed when the config is reloaded
+	mu sync.RWMutex
 }
 
-func NewFilterProcessor() FilterProcessor {
-	return FilterProcessor{
-		Filters: make([]func(req *http.Request, fields log.Fields) bool, 0),
+func NewFilterProcessor() *FilterProcessor {
+	return &FilterProcessor{
+		Filters: make([]*Filter, 0),
 	}
 }
 
-func (fp *FilterProcessor) AddFilter(filter func(req *http.Request, fields log.Fields) bool) {
-	fp.Filters = append(fp.Filters, filter)
+func (fp *FilterProcessor) AddFilter(name string, filter func(req *http.Request, fields log.Fields) bool) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	fp.Filters = append(fp.Filters, &Filter{
+		Name: name,
+		Fn:   filter,
+	})
 }
 
 func (fp *FilterProcessor) Process(req *http.Request, fields log.Fields) bool {
+	fp.mu.RLock()
+	filters := fp.Filters
+	fp.mu.RUnlock()
+
 	// If a single filter returns false, then we stop execution and don't process
-	for _, filter := range fp.Filters {
-		if filter(req, fields) == false {
+	for _, filter := range filters {
+		if filter.Fn(req, fields) == false {
+			atomic.AddUint64(&filter.rejections, 1)
+			RequestLog(req).WithField("filter", filter.Name).Debug("Rejected by filter")
+
+			if fp.OnReject != nil {
+				fp.OnReject(filter.Name, req, fields)
+			}
+
 			return false
 		}
 	}
 
 	return true
 }
+
+func (fp *FilterProcessor) Stats() []FilterStats {
+	fp.mu.RLock()
+	defer fp.mu.RUnlock()
+
+	stats := make([]FilterStats, 0, len(fp.Filters))
+
+	for _, filter := range fp.Filters {
+		stats = append(stats, FilterStats{
+			Name:       filter.Name,
+			Rejections: filter.Rejections(),
+		})
+	}
+
+	return stats
+}
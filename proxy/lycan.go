@@ -2,21 +2,27 @@ package proxy
 
 import (
 	"elasticsearch-proxy/lycan"
-	"elasticsearch-proxy/util"
 	"fmt"
 	"github.com/apex/log"
 	"github.com/tidwall/gjson"
-	"net"
 	"net/http"
 	"net/url"
 )
 
 func NewLycanReverseProxyHandler(ctx *ReverseProxyHandlerContext) ReverseProxyHandler {
-	ctx.Proxy.Transport = &MiddlewareTransport{
-		http.DefaultTransport,
-		nil,
-		ctx,
-		ProcessLycanRequest,
+	transport := &MiddlewareTransport{
+		RoundTripper:               ctx.Proxy.Transport,
+		ReverseProxyHandlerContext: ctx,
+		MiddlewareRoutine:          ProcessLycanRequest,
+	}
+	hostConfig := ctx.Config.Proxy.Lycan
+	transport.SetBodyLimits(hostConfig.ParseMaxRequestBodySize(), hostConfig.ParseMaxCaptureSize(), hostConfig.ParseMaxDecodedSize())
+	transport.SetCompression(hostConfig.Compression)
+
+	ctx.Proxy.Transport = transport
+
+	if err := ctx.LoggingFilters.AddRules(ctx.Config.Proxy.Lycan.Filters); err != nil {
+		panic(err)
 	}
 
 	return NewBasicReverseProxyHandler(ctx)
@@ -33,7 +39,7 @@ func ProcessLycanRequest(ctx ReverseProxyHandlerContext, req *http.Request, resp
 
 	if requestType != RequestPriceRequest {
 		fields := GenerateDefaultFields(requestType, requestedUrl, req)
-		util.LogData(&fields)
+		ctx.LogGeneric(fields)
 
 		return
 	}
@@ -48,22 +54,14 @@ func ProcessLycanRequest(ctx ReverseProxyHandlerContext, req *http.Request, resp
 
 	if ctx.LoggingFilters.Process(req, fields) {
 		// Since this is the elasticsearch queries, we want to de-bounce which is handled by the queue
-		ctx.Queue.Channel <- QueueLogEntry{
-			Key:    fmt.Sprintf("%s", fields.Get("ip")),
-			Fields: fields,
-		}
+		ctx.Enqueue(ctx.Queue, req, fmt.Sprintf("%s", fields.Get("ip")), fields)
 	} else {
-		log.Debug("Request did not match the provided filters")
+		RequestLog(req).Debug("Request did not match the provided filters")
 	}
 }
 
 func GenerateLycanQueryFields(requestType int, requestedUrl string, req *http.Request, queryResponse gjson.Result, statusCode int) log.Fields {
-	// Since we know this is an ES query, we can extract the index
-	ip, _, err := net.SplitHostPort(req.RemoteAddr)
-
-	if err != nil {
-		log.Error("Failed to split the host/port on remote address: " + req.RemoteAddr)
-	}
+	ip := GetClientIp(req)
 
 	parsedOrigin, err := url.Parse(req.Header.Get("Origin"))
 	host := ""
@@ -79,6 +77,8 @@ func GenerateLycanQueryFields(requestType int, requestedUrl string, req *http.Re
 		"ip":       ip,
 		"index":    req.Header.Get("X-Index"),
 		"userAgent": req.Header.Get("User-Agent"),
+		"requestId": GetTraceContext(req).RequestId,
+		"traceId":   GetTraceContext(req).TraceId,
 		"rawParams": req.URL.Query().Encode(),
 		"data":     lycan.ExtractPriceRequestData(req.URL.Query(), queryResponse, statusCode),
 	}
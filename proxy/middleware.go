@@ -7,11 +7,11 @@ import (
 	"elasticsearch-proxy/cache"
 	"elasticsearch-proxy/util"
 	"fmt"
-	"github.com/apex/log"
 	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
 	"strconv"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,13 +20,74 @@ type MiddlewareTransport struct {
 	Cache                      *cache.Storage
 	ReverseProxyHandlerContext *ReverseProxyHandlerContext
 	MiddlewareRoutine          func(ctx ReverseProxyHandlerContext, req *http.Request, resp *http.Response, decodedRequestBody string, decodedResponseBody string)
+
+	// Nanoseconds and bytes, accessed atomically as they can be changed by a config reload
+	cacheTtl           int64
+	maxRequestBodySize int64
+	maxCaptureSize     int64
+	maxDecodedSize     int64
+
+	// compressionSettings, replaced on a config reload
+	compression atomic.Value
+}
+
+func (t *MiddlewareTransport) SetCacheTtl(ttl time.Duration) {
+	atomic.StoreInt64(&t.cacheTtl, int64(ttl))
+}
+
+func (t *MiddlewareTransport) CacheTtl() time.Duration {
+	return time.Duration(atomic.LoadInt64(&t.cacheTtl))
+}
+
+// Zero means there is no limit, the capture size is also used as the cache entry limit
+func (t *MiddlewareTransport) SetBodyLimits(maxRequestBodySize int64, maxCaptureSize int64, maxDecodedSize int64) {
+	atomic.StoreInt64(&t.maxRequestBodySize, maxRequestBodySize)
+	atomic.StoreInt64(&t.maxCaptureSize, maxCaptureSize)
+	atomic.StoreInt64(&t.maxDecodedSize, maxDecodedSize)
+}
+
+func (t *MiddlewareTransport) MaxRequestBodySize() int64 {
+	return atomic.LoadInt64(&t.maxRequestBodySize)
+}
+
+func (t *MiddlewareTransport) MaxCaptureSize() int64 {
+	return atomic.LoadInt64(&t.maxCaptureSize)
+}
+
+func (t *MiddlewareTransport) MaxDecodedSize() int64 {
+	return atomic.LoadInt64(&t.maxDecodedSize)
 }
 
 func (t *MiddlewareTransport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	// Nothing is logged or cached for the other requests (eg. _bulk uploads and scrolls) so they are streamed
+	if DetermineRequestType(req) == RequestGeneric {
+		return t.PassThrough(req)
+	}
+
 	var decodedRequestBody []byte
 
 	if req.URL != nil && req.Header != nil && req.Body != nil {
-		decodedRequestBody = util.DecodeRequestBodyToBytes(req)
+		captured, body, complete, err := util.CaptureBody(req.Body, t.MaxCaptureSize())
+		req.Body = body
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !complete {
+			RequestLog(req).WithField("url", req.URL.String()).Debug("Request body is over the capture size, streaming without logging")
+
+			return t.RoundTripper.RoundTrip(req)
+		}
+
+		// Our SDK compresses larger _msearch bodies, the upstream still receives them as they were sent
+		decodedRequestBody, err = util.DecodeBody(captured, req.Header.Get("Content-Encoding"), t.MaxDecodedSize())
+
+		if err != nil {
+			RequestLog(req).WithError(err).WithField("url", req.URL.String()).Warn("Could not decode the request body, streaming without logging")
+
+			return t.RoundTripper.RoundTrip(req)
+		}
 	}
 
 	// We need to check the cache now
@@ -41,7 +102,9 @@ func (t *MiddlewareTransport) RoundTrip(req *http.Request) (resp *http.Response,
 		cachedResp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(cachedBytes)), req)
 
 		if err != nil {
-			log.Errorf("Could not read response for key: "+hash, err)
+			RequestLog(req).WithError(err).Error("Could not read response for key: " + hash)
+
+			return t.DoRoundTrip(req, decodedRequestBody, hash)
 		}
 
 		cachedResp.Header.Set("X-Cached", hash)
@@ -52,26 +115,45 @@ func (t *MiddlewareTransport) RoundTrip(req *http.Request) (resp *http.Response,
 		// before it has a chance to execute
 		// go t.DoRoundTrip(req, decodedRequestBody, hash)
 
+		cachedBody, err := util.DecodeResponseBodyToBytes(cachedResp)
+
+		if err != nil {
+			return nil, err
+		}
+
 		// We still want to "log" this request though
-		if t.MiddlewareRoutine != nil && len(cachedBytes) > 0 {
+		if t.MiddlewareRoutine != nil && len(cachedBody) > 0 {
 			go t.MiddlewareRoutine(
 				*t.ReverseProxyHandlerContext,
 				req,
-				resp,
+				cachedResp,
 				string(decodedRequestBody),
-				util.DecodeResponseBytes(
-					util.DecodeResponseBodyToBytes(cachedResp),
-					cachedResp.Header.Get("Content-Encoding"),
-				),
+				string(cachedBody),
 			)
 		}
 
+		// Entries are stored decoded, so they are compressed here for this client
+		if err := t.negotiateEncoding(req, cachedResp); err != nil {
+			return nil, err
+		}
+
 		return cachedResp, nil
 	}
 
 	return t.DoRoundTrip(req, decodedRequestBody, hash)
 }
 
+// Forwards the request without reading either body, the middleware still sees it for the debug logging
+func (t *MiddlewareTransport) PassThrough(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+
+	if err == nil && t.MiddlewareRoutine != nil {
+		go t.MiddlewareRoutine(*t.ReverseProxyHandlerContext, req, resp, "", "")
+	}
+
+	return resp, err
+}
+
 func (t *MiddlewareTransport) DoRoundTrip(req *http.Request, decodedRequestBody []byte, hash string) (resp *http.Response, err error) {
 	// This is where the request is forwarded on to its configured address
 	resp, err = t.RoundTripper.RoundTrip(req)
@@ -82,64 +164,98 @@ func (t *MiddlewareTransport) DoRoundTrip(req *http.Request, decodedRequestBody
 		return nil, err
 	}
 
-	respBytes, err := ioutil.ReadAll(resp.Body)
+	// Negotiating the encoding for the client replaces the header, the copy is in the upstream encoding
+	upstreamEncoding := resp.Header.Get("Content-Encoding")
 
-	if err != nil {
-		return nil, err
-	}
+	// The client receives the body as it arrives, a copy is kept (up to the capture size) for the middleware
+	// and the cache which is handed over once the client has read all of it
+	resp.Body = util.NewCaptureReadCloser(resp.Body, t.MaxCaptureSize(), func(respBytes []byte) {
+		if len(respBytes) == 0 {
+			return
+		}
 
-	err = resp.Body.Close()
-	if err != nil {
-		return nil, err
-	}
+		// The middleware still runs when the response cannot be decoded, it just has no response body to work with
+		decodedBytes, decodeErr := util.DecodeBody(respBytes, upstreamEncoding, t.MaxDecodedSize())
 
-	// We can modify the response here
-	if t.MiddlewareRoutine != nil && len(respBytes) > 0 {
-		go t.MiddlewareRoutine(
-			*t.ReverseProxyHandlerContext,
-			req,
-			resp,
-			string(decodedRequestBody),
-			util.DecodeResponseBytes(
-				respBytes,
-				resp.Header.Get("Content-Encoding"),
-			),
-		)
-	}
+		if decodeErr != nil {
+			RequestLog(req).WithError(decodeErr).WithField("url", req.URL.String()).Error("Could not decode the response body")
+		}
 
-	body := ioutil.NopCloser(bytes.NewReader(respBytes))
-	resp.Body = body
-	resp.ContentLength = int64(len(respBytes))
-	resp.Header.Set("Content-Length", strconv.Itoa(len(respBytes)))
+		// We can modify the response here
+		if t.MiddlewareRoutine != nil {
+			go t.MiddlewareRoutine(
+				*t.ReverseProxyHandlerContext,
+				req,
+				resp,
+				string(decodedRequestBody),
+				string(decodedBytes),
+			)
+		}
+
+		// Stored decoded so each client can be sent the encoding it accepts
+		if t.Cache != nil && decodeErr == nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			if duration := t.CacheTtl(); duration > 0 {
+				cachedResp := &http.Response{
+					Status:        resp.Status,
+					StatusCode:    resp.StatusCode,
+					Proto:         resp.Proto,
+					ProtoMajor:    resp.ProtoMajor,
+					ProtoMinor:    resp.ProtoMinor,
+					Header:        resp.Header.Clone(),
+					Body:          ioutil.NopCloser(bytes.NewReader(decodedBytes)),
+					ContentLength: int64(len(decodedBytes)),
+				}
+				cachedResp.Header.Del("Content-Encoding")
+				cachedResp.Header.Set("Content-Length", strconv.Itoa(len(decodedBytes)))
 
-	if t.Cache != nil && resp.StatusCode >= 200 && resp.StatusCode < 300 && len(respBytes) > 0 {
-		if duration, err := time.ParseDuration("10s"); err == nil {
-			wholeRespBytes, _ := httputil.DumpResponse(resp, true)
+				wholeRespBytes, _ := httputil.DumpResponse(cachedResp, true)
 
-			go t.Cache.Set(hash, wholeRespBytes, duration)
+				go t.Cache.Set(hash, wholeRespBytes, duration)
+			}
 		}
+	})
+
+	if err := t.negotiateEncoding(req, resp); err != nil {
+		resp.Body.Close()
+
+		return nil, err
 	}
 
 	return resp, nil
 }
 
-func addCorsHeader(res http.ResponseWriter) {
-	headers := res.Header()
-	headers.Add("X-Cors", "Yes")
-	headers.Add("Access-Control-Allow-Origin", "*")
-	headers.Add("Access-Control-Allow-Headers", "Content-Type,Origin,Accept,Token,Authorization,X-App,X-Index,Bypass-Tunnel-Reminder")
-	headers.Add("Access-Control-Allow-Methods", "GET,POST,PUT,DELETE,PATCH,OPTIONS")
-}
-
 func NewBasicReverseProxyHandler(ctx *ReverseProxyHandlerContext) func(res http.ResponseWriter, req *http.Request) {
 	return func(res http.ResponseWriter, req *http.Request) {
 
 		// This is called BEFORE the RoundTrip intercept is via ServeHTTP
 
-		if req.Method == "OPTIONS" {
-			addCorsHeader(res)
-			res.WriteHeader(http.StatusOK)
-			return
+		// Resolved once so logging and debouncing all agree on who the visitor is
+		req = WithClientIp(req, ctx.TrustedProxies.ClientIp(req))
+
+		traceContext := NewTraceContext(req)
+		req = WithTraceContext(req, traceContext)
+		traceContext.SetHeaders(req.Header)
+		traceContext.SetHeaders(res.Header())
+
+		if ctx.Cors != nil {
+			ctx.Cors.Apply(res, req)
+
+			if IsPreflightRequest(req) {
+				res.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+
+		if transport, ok := ctx.Proxy.Transport.(*MiddlewareTransport); ok && req.Body != nil {
+			if limit := transport.MaxRequestBodySize(); limit > 0 {
+				if req.ContentLength > limit {
+					http.Error(res, "Request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+
+				// Chunked uploads are cut off once they go over
+				req.Body = http.MaxBytesReader(res, req.Body, limit)
+			}
 		}
 
 		req.URL.Host = ctx.Target.Host
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"bytes"
+	"elasticsearch-proxy/cache"
+	"elasticsearch-proxy/util"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type capturedBodies struct {
+	request  string
+	response string
+}
+
+func newTestTransport(captured chan capturedBodies, maxCaptureSize int64) *MiddlewareTransport {
+	transport := &MiddlewareTransport{
+		RoundTripper:               http.DefaultTransport,
+		ReverseProxyHandlerContext: &ReverseProxyHandlerContext{},
+		MiddlewareRoutine: func(ctx ReverseProxyHandlerContext, req *http.Request, resp *http.Response, decodedRequestBody string, decodedResponseBody string) {
+			captured <- capturedBodies{decodedRequestBody, decodedResponseBody}
+		},
+	}
+	transport.SetBodyLimits(0, maxCaptureSize, 0)
+
+	return transport
+}
+
+func TestMiddlewareTransport(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		w.Write([]byte(`{"responses":[],"echo":"` + strings.Repeat("x", len(body)) + `"}`))
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name           string
+		path           string
+		body           string
+		maxCaptureSize int64
+		expected       *capturedBodies
+	}{
+		{"search is captured", "/properties/_msearch", "{}\n{}", 1024, &capturedBodies{"{}\n{}", `{"responses":[],"echo":"xxxxx"}`}},
+		{"generic request is streamed", "/_bulk", "{}\n{}", 1024, &capturedBodies{"", ""}},
+		{"request over the capture size is not logged", "/properties/_msearch", strings.Repeat("a", 100), 50, nil},
+		{"response over the capture size is not logged", "/properties/_msearch", "{}", 20, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			captured := make(chan capturedBodies, 1)
+			transport := newTestTransport(captured, test.maxCaptureSize)
+
+			req, _ := http.NewRequest("POST", upstream.URL+test.path, strings.NewReader(test.body))
+			resp, err := transport.RoundTrip(req)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The client always gets the whole response
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if expected := `{"responses":[],"echo":"` + strings.Repeat("x", len(test.body)) + `"}`; string(body) != expected {
+				t.Errorf("expected %s, got %s", expected, body)
+			}
+
+			select {
+			case bodies := <-captured:
+				if test.expected == nil {
+					t.Errorf("expected nothing to be logged, got %+v", bodies)
+				} else if bodies != *test.expected {
+					t.Errorf("expected %+v, got %+v", *test.expected, bodies)
+				}
+			case <-time.After(100 * time.Millisecond):
+				if test.expected != nil {
+					t.Error("expected the middleware to be called")
+				}
+			}
+		})
+	}
+
+	t.Run("captured responses are cached", func(t *testing.T) {
+		captured := make(chan capturedBodies, 2)
+		transport := newTestTransport(captured, 1024)
+		transport.Cache = cache.NewStorage()
+		transport.SetCacheTtl(time.Minute)
+
+		for i := 0; i < 2; i++ {
+			req, _ := http.NewRequest("POST", upstream.URL+"/properties/_msearch", strings.NewReader("{}"))
+			resp, err := transport.RoundTrip(req)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if cached := resp.Header.Get("X-Cached") != ""; cached != (i == 1) {
+				t.Errorf("request %d: expected cached %v", i, i == 1)
+			}
+
+			<-captured
+
+			// The cache is written in the background
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+func TestMiddlewareTransportDecodesBodies(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := util.EncodeBody([]byte(`{"responses":[]}`), "br")
+
+		w.Header().Set("Content-Encoding", "br")
+		w.Write(body)
+	}))
+	defer upstream.Close()
+
+	captured := make(chan capturedBodies, 1)
+	transport := newTestTransport(captured, 1024)
+
+	body, _ := util.EncodeBody([]byte("{}\n{}"), "gzip")
+	req, _ := http.NewRequest("POST", upstream.URL+"/properties/_msearch", bytes.NewReader(body))
+	req.Header.Set("Content-Encoding", "gzip")
+
+	resp, err := transport.RoundTrip(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if bodies := <-captured; bodies != (capturedBodies{"{}\n{}", `{"responses":[]}`}) {
+		t.Errorf("expected the decoded bodies, got %+v", bodies)
+	}
+}
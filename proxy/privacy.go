@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"elasticsearch-proxy/config"
+	"elasticsearch-proxy/elasticsearch"
+	"elasticsearch-proxy/util"
+	"github.com/apex/log"
+)
+
+// Applied to every entry just before it is queued, after enrichment (which needs the raw IP) and after the
+// debounce key has been taken so the raw IP never leaves memory
+type PrivacyProcessor struct {
+	Config config.PrivacyConfig
+}
+
+func NewPrivacyProcessor(cfg config.PrivacyConfig) *PrivacyProcessor {
+	return &PrivacyProcessor{
+		Config: cfg,
+	}
+}
+
+func (p *PrivacyProcessor) Process(fields log.Fields) {
+	if ip, ok := fields.Get("ip").(string); ok && ip != "" {
+		switch p.Config.IpMode {
+		case config.IpModeTruncate:
+			fields["ip"] = util.TruncateIp(ip)
+		case config.IpModeHmac:
+			fields["ip"] = util.HashIp(ip, p.Config.HmacKey)
+		}
+	}
+
+	if p.Config.DropRawQuery {
+		delete(fields, "rawQuery")
+		delete(fields, "rawParams")
+	}
+
+	if !p.Config.ScrubPii {
+		return
+	}
+
+	if rawQuery, ok := fields.Get("rawQuery").(string); ok {
+		fields["rawQuery"] = util.ScrubPiiJson(rawQuery)
+	}
+
+	if rawParams, ok := fields.Get("rawParams").(string); ok {
+		fields["rawParams"] = util.ScrubPiiUrlValues(rawParams)
+	}
+
+	if requestedUrl, ok := fields.Get("url").(string); ok {
+		fields["url"] = util.ScrubPiiUrl(requestedUrl)
+	}
+
+	if metrics, ok := fields.Get("data").(map[string]interface{}); ok {
+		if metric, exists := elasticsearch.FindMetricByName(elasticsearch.MetricPropertySearch, metrics); exists {
+			search := metric.(elasticsearch.MetricKeywordSearchData)
+			search.Term = util.ScrubPii(search.Term)
+
+			metrics[elasticsearch.MetricPropertySearch] = search
+		}
+	}
+}
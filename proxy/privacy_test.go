@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"elasticsearch-proxy/config"
+	"elasticsearch-proxy/elasticsearch"
+	"elasticsearch-proxy/util"
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestPrivacyProcessor(t *testing.T) {
+	target, _ := url.Parse("http://localhost:9200")
+
+	newFields := func() log.Fields {
+		return log.Fields{
+			"ip":        "203.0.113.7",
+			"url":       "/properties/_search?email=john.smith@example.com&page=2",
+			"rawParams": "email=john.smith@example.com&page=2",
+			"rawQuery":  `{"query":{"multi_match":{"query":"john.smith@example.com","size":10}}}`,
+			"data": map[string]interface{}{
+				elasticsearch.MetricPropertySearch: elasticsearch.MetricKeywordSearchData{Term: "call 07700 900123"},
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		privacy  config.PrivacyConfig
+		ip       string
+		scrubbed bool
+		dropped  bool
+	}{
+		{"raw", config.PrivacyConfig{}, "203.0.113.7", false, false},
+		{"truncated ip", config.PrivacyConfig{IpMode: config.IpModeTruncate}, "203.0.113.0", false, false},
+		{"hmac ip", config.PrivacyConfig{IpMode: config.IpModeHmac, HmacKey: "secret"}, util.HashIp("203.0.113.7", "secret"), false, false},
+		{"scrubbed", config.PrivacyConfig{ScrubPii: true}, "203.0.113.7", true, false},
+		{"raw query dropped", config.PrivacyConfig{ScrubPii: true, DropRawQuery: true}, "203.0.113.7", true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			queue := NewQueue(time.Second, log.Logger{})
+			ctx := NewReverseProxyHandlerContext(target, NewSingleHostReverseProxy(target, nil), &queue)
+			ctx.Privacy = NewPrivacyProcessor(test.privacy)
+
+			// Enrichers (eg. geo) run before the privacy processor so they still see the raw IP
+			var enrichedIp interface{}
+			ctx.Enrichment.AddEnricher(func(req *http.Request, fields log.Fields) {
+				enrichedIp = fields.Get("ip")
+			})
+
+			req := httptest.NewRequest("POST", "/properties/_search", nil)
+			ctx.Enqueue(&queue, req, "203.0.113.7", newFields())
+
+			entry := <-queue.Channel
+
+			if entry.Key != "203.0.113.7" || enrichedIp != "203.0.113.7" {
+				t.Errorf("expected the debounce key and enrichers to use the raw ip, got %q and %v", entry.Key, enrichedIp)
+			}
+
+			if ip := entry.Fields.Get("ip"); ip != test.ip {
+				t.Errorf("expected ip %q, got %v", test.ip, ip)
+			}
+
+			term := entry.Fields.Get("data").(map[string]interface{})[elasticsearch.MetricPropertySearch].(elasticsearch.MetricKeywordSearchData).Term
+
+			if test.scrubbed {
+				if url := entry.Fields.Get("url"); url != "/properties/_search?email=%5Bemail%5D&page=2" {
+					t.Errorf("expected the url to be scrubbed, got %v", url)
+				}
+
+				if term != "call [phone]" {
+					t.Errorf("expected the search term to be scrubbed, got %q", term)
+				}
+			} else if term != "call 07700 900123" || entry.Fields.Get("url") != newFields().Get("url") {
+				t.Errorf("expected the url and search term to be untouched, got %v and %q", entry.Fields.Get("url"), term)
+			}
+
+			_, hasRawQuery := entry.Fields["rawQuery"]
+			_, hasRawParams := entry.Fields["rawParams"]
+
+			switch {
+			case test.dropped && (hasRawQuery || hasRawParams):
+				t.Errorf("expected rawQuery and rawParams to be dropped, got %v", entry.Fields)
+			case test.scrubbed && !test.dropped:
+				if rawQuery := entry.Fields.Get("rawQuery"); rawQuery != `{"query":{"multi_match":{"query":"[email]","size":10}}}` {
+					t.Errorf("expected rawQuery to be scrubbed, got %v", rawQuery)
+				}
+
+				if rawParams := entry.Fields.Get("rawParams"); rawParams != "email=%5Bemail%5D&page=2" {
+					t.Errorf("expected rawParams to be scrubbed, got %v", rawParams)
+				}
+			case !test.scrubbed && entry.Fields.Get("rawQuery") != newFields().Get("rawQuery"):
+				t.Errorf("expected rawQuery to be untouched, got %v", entry.Fields.Get("rawQuery"))
+			}
+		})
+	}
+}
+
+func TestLogGenericAppliesPrivacy(t *testing.T) {
+	handler := memory.New()
+	previous := log.Log
+	log.Log = &log.Logger{Handler: handler, Level: log.DebugLevel}
+	defer func() { log.Log = previous }()
+
+	target, _ := url.Parse("http://localhost:9200")
+	queue := NewQueue(time.Second, log.Logger{})
+	ctx := NewReverseProxyHandlerContext(target, NewSingleHostReverseProxy(target, nil), &queue)
+	ctx.Privacy = NewPrivacyProcessor(config.PrivacyConfig{IpMode: config.IpModeTruncate})
+
+	req := httptest.NewRequest("GET", "/_cluster/health", nil)
+	req.RemoteAddr = "203.0.113.7:1234"
+
+	ctx.LogGeneric(GenerateDefaultFields(RequestGeneric, req.URL.String(), req))
+
+	if len(handler.Entries) != 1 || handler.Entries[0].Fields.Get("ip") != "203.0.113.0" {
+		t.Errorf("expected the generic request to be logged with the truncated ip, got %+v", handler.Entries)
+	}
+}
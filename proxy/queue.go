@@ -19,6 +19,12 @@ type Queue struct {
 	Items            map[string]*QueueItem
 	Mutex            sync.Mutex
 	Logger			 log.Logger
+
+	// Optional, called with the final debounced entry after it has been logged
+	OnFlush func(fields log.Fields)
+
+	// Optional, the number of refinements (metric changes between the debounced queries) attached to the final entry
+	RefinementHistory int
 }
 
 type QueueLogEntry struct {
@@ -46,6 +52,34 @@ func NewQueue(debounce time.Duration, logger log.Logger) Queue {
 	}
 }
 
+func (q *Queue) SetDebounceInterval(debounce time.Duration) {
+	q.Mutex.Lock()
+	defer q.Mutex.Unlock()
+
+	q.DebounceInterval = debounce
+}
+
+func (q *Queue) getDebounceInterval() time.Duration {
+	q.Mutex.Lock()
+	defer q.Mutex.Unlock()
+
+	return q.DebounceInterval
+}
+
+func (q *Queue) SetRefinementHistory(maxSteps int) {
+	q.Mutex.Lock()
+	defer q.Mutex.Unlock()
+
+	q.RefinementHistory = maxSteps
+}
+
+func (q *Queue) getRefinementHistory() int {
+	q.Mutex.Lock()
+	defer q.Mutex.Unlock()
+
+	return q.RefinementHistory
+}
+
 func (q *Queue) Start() {
 	for {
 		select {
@@ -63,7 +97,7 @@ func (q *Queue) Start() {
 			}
 
 			q.Items[key].AddLog(queueLogEntry.Fields)
-		case <-time.After(q.DebounceInterval):
+		case <-time.After(q.getDebounceInterval()):
 			// Now we need "flush" the logs per IP and pull the last request out of each of them
 			for _, qi := range q.Items {
 
@@ -76,8 +110,18 @@ func (q *Queue) Start() {
 
 				fields := lastEntry.Fields()
 
+				if maxSteps := q.getRefinementHistory(); maxSteps > 0 && len(qi.Logs) > 1 {
+					if refinements := NewRefinementHistory(qi.Logs, maxSteps); len(refinements) > 0 {
+						fields["refinements"] = refinements
+					}
+				}
+
 				log.WithFields(fields).Info(fmt.Sprintf(util.LogMsg("Added to buffer (debounced %d queries)"), len(qi.Logs)))
 				q.Logger.WithFields(fields).Info(fmt.Sprintf("%v", fields.Get("url")))
+
+				if q.OnFlush != nil {
+					q.OnFlush(fields)
+				}
 			}
 
 			// Now we reset the map as we have done our "logging"
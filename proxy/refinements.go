@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"elasticsearch-proxy/elasticsearch"
+	"github.com/apex/log"
+)
+
+// One change between two successive debounced queries, step is the position of the query that made the change
+type Refinement struct {
+	Step int `json:"step"`
+	elasticsearch.MetricDiff
+}
+
+// Builds the ordered metric changes between the debounced queries of a visitor, duplicate queries are skipped and
+// only the changes of the last maxSteps refinements are kept as they are the ones that led to the final search
+func NewRefinementHistory(logs []log.Fields, maxSteps int) []Refinement {
+	steps := make([][]Refinement, 0)
+
+	for i := 1; i < len(logs); i++ {
+		previous, previousOk := logs[i-1].Get("data").(map[string]interface{})
+		current, currentOk := logs[i].Get("data").(map[string]interface{})
+
+		if !previousOk || !currentOk {
+			continue
+		}
+
+		step := make([]Refinement, 0)
+
+		for _, diff := range elasticsearch.DiffMetrics(previous, current) {
+			step = append(step, Refinement{Step: i, MetricDiff: diff})
+		}
+
+		if len(step) > 0 {
+			steps = append(steps, step)
+		}
+	}
+
+	if maxSteps > 0 && len(steps) > maxSteps {
+		steps = steps[len(steps)-maxSteps:]
+	}
+
+	history := make([]Refinement, 0)
+
+	for _, step := range steps {
+		history = append(history, step...)
+	}
+
+	return history
+}
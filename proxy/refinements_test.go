@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"elasticsearch-proxy/elasticsearch"
+	"fmt"
+	"github.com/apex/log"
+	"testing"
+)
+
+func TestNewRefinementHistory(t *testing.T) {
+	search := func(metrics map[string]interface{}) log.Fields {
+		metrics[elasticsearch.MetricResponse] = elasticsearch.MetricResponseData{QueryTimeMs: 12}
+
+		return log.Fields{"data": metrics}
+	}
+
+	bedrooms := elasticsearch.MetricRangeData{Minimum: 2, Maximum: 3}
+	dates := elasticsearch.MetricDateRangeData{ArrivalDate: "2020-06-01", DepartureDate: "2020-06-08", Nights: 7}
+	laterDates := elasticsearch.MetricDateRangeData{ArrivalDate: "2020-07-01", DepartureDate: "2020-07-08", Nights: 7}
+
+	logs := []log.Fields{
+		search(map[string]interface{}{}),
+		search(map[string]interface{}{elasticsearch.MetricBedrooms: bedrooms}),
+		// Duplicate of the previous query, not a refinement
+		search(map[string]interface{}{elasticsearch.MetricBedrooms: bedrooms}),
+		search(map[string]interface{}{elasticsearch.MetricBedrooms: bedrooms, elasticsearch.MetricDateRange: dates}),
+		search(map[string]interface{}{elasticsearch.MetricDateRange: laterDates}),
+	}
+
+	tests := []struct {
+		name     string
+		maxSteps int
+		expected []string
+	}{
+		{"full history", 10, []string{"1 added bedrooms", "3 added dateRange", "4 removed bedrooms", "4 changed dateRange"}},
+		{"capped to the latest refinements", 2, []string{"3 added dateRange", "4 removed bedrooms", "4 changed dateRange"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			history := NewRefinementHistory(logs, test.maxSteps)
+
+			if len(history) != len(test.expected) {
+				t.Fatalf("expected %d refinements, got %+v", len(test.expected), history)
+			}
+
+			for i, refinement := range history {
+				described := fmt.Sprintf("%d %s %s", refinement.Step, refinement.Type, refinement.Metric)
+
+				if described != test.expected[i] {
+					t.Errorf("expected %q, got %q", test.expected[i], described)
+				}
+			}
+		})
+	}
+
+	t.Run("non search entries are ignored", func(t *testing.T) {
+		if history := NewRefinementHistory([]log.Fields{{"data": "price"}, {"data": "price"}}, 10); len(history) != 0 {
+			t.Errorf("expected no refinements, got %+v", history)
+		}
+	})
+}
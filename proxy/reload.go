@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"elasticsearch-proxy/config"
+	"github.com/apex/log"
+)
+
+// Applies the parts of a reloaded config that can change at runtime, see config.RestartRequiredChanges for
+// the rest
+func NewReloadHandler(handlerCfg ReverseProxyHandlerConfig, ctx *ReverseProxyHandlerContext) func(previous config.Config, current config.Config) {
+	return func(previous config.Config, current config.Config) {
+		queueConfig := handlerCfg.QueueConfig(current)
+		ctx.Queue.SetDebounceInterval(queueConfig.ParseDuration())
+		ctx.Queue.SetRefinementHistory(queueConfig.RefinementHistory)
+
+		// Removing the property views needs a restart (see config.RestartRequiredChanges), until then the
+		// queue keeps its current interval
+		if ctx.PropertyViewQueue != nil && current.Logging.PropertyViews.Enabled() {
+			ctx.PropertyViewQueue.SetDebounceInterval(current.Logging.PropertyViews.ParseDuration())
+		}
+
+		hostConfig := handlerCfg.HostConfig(current)
+
+		if err := ctx.LoggingFilters.ReplaceRules(hostConfig.Filters); err != nil {
+			log.Error("Could not reload filters for " + handlerCfg.Name + ", keeping the current filters: " + err.Error())
+		}
+
+		if ctx.Cors != nil {
+			if err := ctx.Cors.Replace(hostConfig.Cors); err != nil {
+				log.Error("Could not reload CORS for " + handlerCfg.Name + ", keeping the current policy: " + err.Error())
+			}
+		}
+
+		if transport, ok := ctx.Proxy.Transport.(*MiddlewareTransport); ok {
+			transport.SetBodyLimits(hostConfig.ParseMaxRequestBodySize(), hostConfig.ParseMaxCaptureSize(), hostConfig.ParseMaxDecodedSize())
+			transport.SetCompression(hostConfig.Compression)
+
+			if transport.Cache != nil {
+				transport.SetCacheTtl(hostConfig.ParseCacheTtl())
+			}
+		}
+
+		log.Info("Reloaded config for the " + handlerCfg.Name + " handler")
+	}
+}
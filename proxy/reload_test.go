@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"elasticsearch-proxy/config"
+	"github.com/apex/log"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestReloadHandler(t *testing.T) {
+	target, _ := url.Parse("http://localhost:9200")
+
+	queue := NewQueue(time.Second, log.Logger{})
+	propertyViewQueue := NewQueue(time.Second, log.Logger{})
+
+	ctx := NewReverseProxyHandlerContext(target, NewSingleHostReverseProxy(target, nil), &queue)
+	ctx.PropertyViewQueue = &propertyViewQueue
+
+	handlerCfg := ReverseProxyHandlerConfig{
+		Name: "elasticsearch",
+		HostConfig: func(cfg config.Config) config.ProxyHostConfig {
+			return cfg.Proxy.Elasticsearch
+		},
+		QueueConfig: func(cfg config.Config) config.ElasticsearchIndexQueueConfig {
+			return cfg.Logging.ElasticsearchQueries
+		},
+	}
+
+	previous := config.Config{}
+	previous.Logging.ElasticsearchQueries.QueryDebounceDuration = "1s"
+	previous.Logging.PropertyViews = config.ElasticsearchIndexQueueConfig{Index: "property-views", QueryDebounceDuration: "1s"}
+
+	t.Run("debounce intervals are applied", func(t *testing.T) {
+		current := previous
+		current.Logging.ElasticsearchQueries.QueryDebounceDuration = "5s"
+		current.Logging.PropertyViews.QueryDebounceDuration = "2s"
+
+		NewReloadHandler(handlerCfg, &ctx)(previous, current)
+
+		if interval := queue.getDebounceInterval(); interval != 5*time.Second {
+			t.Errorf("expected 5s, got %v", interval)
+		}
+
+		if interval := propertyViewQueue.getDebounceInterval(); interval != 2*time.Second {
+			t.Errorf("expected 2s, got %v", interval)
+		}
+	})
+
+	t.Run("removing the property views does not panic", func(t *testing.T) {
+		current := previous
+		current.Logging.PropertyViews = config.ElasticsearchIndexQueueConfig{}
+
+		NewReloadHandler(handlerCfg, &ctx)(previous, current)
+
+		if interval := propertyViewQueue.getDebounceInterval(); interval != 2*time.Second {
+			t.Errorf("expected the interval to be kept until a restart, got %v", interval)
+		}
+	})
+}
@@ -4,9 +4,11 @@ import (
 	"crypto/tls"
 	"elasticsearch-proxy/config"
 	"elasticsearch-proxy/elasticsearch"
+	"elasticsearch-proxy/geo"
+	"elasticsearch-proxy/useragent"
+	"elasticsearch-proxy/util"
 	"github.com/apex/log"
 	"github.com/caddyserver/certmagic"
-	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -18,20 +20,35 @@ const (
 	RequestGeneric = iota
 	RequestElasticsearch
 	RequestPriceRequest
+	RequestPropertyView
 )
 
 type ReverseProxyHandlerConfig struct {
+	Name string
 	MuxPattern string
 	TargetUrl *url.URL
 	Queue *Queue
+	PropertyViewQueue *Queue
 	ProxyHandler func(ctx *ReverseProxyHandlerContext) ReverseProxyHandler
+
+	// Select this handler's sections of the config, used when the config is reloaded
+	HostConfig  func(cfg config.Config) config.ProxyHostConfig
+	QueueConfig func(cfg config.Config) config.ElasticsearchIndexQueueConfig
 }
 
 type ReverseProxyHandlerContext struct {
 	Target         *url.URL
 	Proxy          *httputil.ReverseProxy
 	Queue          *Queue
-	LoggingFilters FilterProcessor
+	LoggingFilters *FilterProcessor
+	Enrichment     EnrichmentProcessor
+	TrustedProxies TrustedProxies
+	Privacy        *PrivacyProcessor
+	Cors           *CorsPolicy
+
+	// Optional, single record lookups are sent here instead of the main queue
+	PropertyViewQueue *Queue
+	Config            *config.Config
 }
 
 type ReverseProxyHandler func(res http.ResponseWriter, req *http.Request)
@@ -42,15 +59,24 @@ func GetRequestTypeString(requestType int) string {
 		return "ELASTICSEARCH"
 	case RequestPriceRequest:
 		return "PRICE_REQUEST"
+	case RequestPropertyView:
+		return "PROPERTY_VIEW"
 	}
 
 	return "GENERIC"
 }
 
-func NewSingleHostReverseProxy(targetUrl *url.URL) *httputil.ReverseProxy {
+func NewSingleHostReverseProxy(targetUrl *url.URL, tlsConfig *tls.Config) *httputil.ReverseProxy {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
 	rp := httputil.NewSingleHostReverseProxy(targetUrl)
-	rp.Transport = &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	rp.Transport = transport
+	rp.ModifyResponse = func(resp *http.Response) error {
+		removeUpstreamCorsHeaders(resp)
+		removeUpstreamTraceHeaders(resp)
+
+		return nil
 	}
 
 	return rp
@@ -62,37 +88,162 @@ func NewReverseProxyHandlerContext(targetUrl *url.URL, proxy *httputil.ReversePr
 		Proxy:          proxy,
 		Queue:          queue,
 		LoggingFilters: NewFilterProcessor(),
+		Enrichment:     NewEnrichmentProcessor(),
 	}
 }
 
+// All entries destined for a queue go through here so they are enriched in one place
+func (ctx *ReverseProxyHandlerContext) Enqueue(queue *Queue, req *http.Request, key string, fields log.Fields) {
+	ctx.Enrichment.Process(req, fields)
+
+	if ctx.Privacy != nil {
+		ctx.Privacy.Process(fields)
+	}
+
+	queue.Channel <- QueueLogEntry{
+		Key:    key,
+		Fields: fields,
+	}
+}
+
+// Requests that are not queued are only debug logged, the privacy settings still apply to them
+func (ctx *ReverseProxyHandlerContext) LogGeneric(fields log.Fields) {
+	if ctx.Privacy != nil {
+		ctx.Privacy.Process(fields)
+	}
+
+	util.LogData(&fields)
+}
+
 func ConfigureAndStartProxyServer(cfg config.Config) {
 	mux := http.NewServeMux()
 
 	lycanQueue := NewQueue(cfg.Logging.LycanPriceRequests.ParseDuration(), *elasticsearch.LycanPriceRequestLogger)
 	esQueue := NewQueue(cfg.Logging.ElasticsearchQueries.ParseDuration(), *elasticsearch.EsQueryLogger)
+	esQueue.RefinementHistory = cfg.Logging.ElasticsearchQueries.RefinementHistory
+
+	trustedProxies, err := ParseTrustedProxies(cfg.Server.TrustedProxies, cfg.Server.ParseClientIpHeader())
+	if err != nil {
+		panic(err)
+	}
+
+	zeroResults := NewZeroResultTracker(cfg.Logging.ZeroResults.ParseWindow(), cfg.Logging.ZeroResults.TopN, elasticsearch.ZeroResultLogger)
+	esQueue.OnFlush = zeroResults.Track
+
+	if cfg.Server.Admin.Enabled {
+		mux.Handle(AdminPathPrefix+"zero-results", NewAdminHandler(cfg.Server.Admin, zeroResults))
+	}
+
+	var geoDatabase *geo.Database
+	if cfg.Logging.Geo.DatabasePath != "" {
+		db, err := geo.NewDatabase(cfg.Logging.Geo.DatabasePath, cfg.Logging.Geo.ParseReloadInterval())
+
+		if err != nil {
+			log.Error("Could not open geo database, continuing without geo enrichment: " + err.Error())
+		} else {
+			geoDatabase = db
+		}
+	}
+
+	var userAgentParser *useragent.Parser
+	if cfg.Logging.UserAgent.RulesPath != "" {
+		parser, err := useragent.LoadParserFromFile(cfg.Logging.UserAgent.RulesPath)
+
+		if err != nil {
+			log.Error("Could not load user agent rules, continuing without user agent parsing: " + err.Error())
+		} else {
+			userAgentParser = parser
+		}
+	}
+
+	var propertyViewQueue *Queue
+	if elasticsearch.PropertyViewLogger != nil {
+		queue := NewQueue(cfg.Logging.PropertyViews.ParseDuration(), *elasticsearch.PropertyViewLogger)
+		propertyViewQueue = &queue
+	}
 
 	handlerConfigs := []ReverseProxyHandlerConfig{
 		{
+			Name: "lycan",
 			MuxPattern: "/api/",
 			TargetUrl: cfg.Proxy.Lycan.ParseUrl(),
 			Queue: &lycanQueue,
 			ProxyHandler: NewLycanReverseProxyHandler,
+			HostConfig: func(cfg config.Config) config.ProxyHostConfig {
+				return cfg.Proxy.Lycan
+			},
+			QueueConfig: func(cfg config.Config) config.ElasticsearchIndexQueueConfig {
+				return cfg.Logging.LycanPriceRequests
+			},
 		},
 		{
+			Name: "elasticsearch",
 			MuxPattern: "/",
 			TargetUrl: cfg.Proxy.Elasticsearch.ParseUrl(),
 			Queue: &esQueue,
+			PropertyViewQueue: propertyViewQueue,
 			ProxyHandler: NewElasticsearchReverseProxyHandler,
+			HostConfig: func(cfg config.Config) config.ProxyHostConfig {
+				return cfg.Proxy.Elasticsearch
+			},
+			QueueConfig: func(cfg config.Config) config.ElasticsearchIndexQueueConfig {
+				return cfg.Logging.ElasticsearchQueries
+			},
 		},
 	}
 
+	filterProcessors := make(map[string]*FilterProcessor)
+
 	for _, handlerCfg := range handlerConfigs {
-		reverseProxy := NewSingleHostReverseProxy(handlerCfg.TargetUrl)
+		hostConfig := handlerCfg.HostConfig(cfg)
+		tlsConfig, err := hostConfig.Tls.BuildTlsConfig()
+
+		if err != nil {
+			panic("Could not configure TLS for " + handlerCfg.Name + ": " + err.Error())
+		}
+
+		if tlsConfig.InsecureSkipVerify {
+			log.Warn("TLS certificate verification is disabled for " + handlerCfg.Name)
+		}
+
+		reverseProxy := NewSingleHostReverseProxy(handlerCfg.TargetUrl, tlsConfig)
 		context := NewReverseProxyHandlerContext(handlerCfg.TargetUrl, reverseProxy, handlerCfg.Queue)
+		context.PropertyViewQueue = handlerCfg.PropertyViewQueue
+		context.Config = &cfg
+		context.TrustedProxies = trustedProxies
+		context.Privacy = NewPrivacyProcessor(cfg.Logging.Privacy)
+		context.Cors, err = NewCorsPolicy(hostConfig.Cors)
+
+		if err != nil {
+			panic("Could not configure CORS for " + handlerCfg.Name + ": " + err.Error())
+		}
+
+		if elasticsearch.FilterDebugLogger != nil {
+			context.LoggingFilters.OnReject = NewRejectionSampler(cfg.Logging.FilterDebug.SampleRate, elasticsearch.FilterDebugLogger, context.Privacy)
+		}
+
+		if geoDatabase != nil {
+			context.Enrichment.AddEnricher(NewGeoEnricher(geoDatabase))
+		}
+
+		if userAgentParser != nil {
+			context.Enrichment.AddEnricher(NewUserAgentEnricher(userAgentParser))
+		}
 
 		mux.HandleFunc(handlerCfg.MuxPattern, handlerCfg.ProxyHandler(&context))
+		filterProcessors[handlerCfg.Name] = context.LoggingFilters
+
+		config.OnReload(NewReloadHandler(handlerCfg, &context))
 
 		go handlerCfg.Queue.Start()
+
+		if handlerCfg.PropertyViewQueue != nil {
+			go handlerCfg.PropertyViewQueue.Start()
+		}
+	}
+
+	if cfg.Server.Admin.Enabled {
+		mux.Handle(AdminPathPrefix+"filters", NewAdminHandler(cfg.Server.Admin, NewFilterStatsHandler(filterProcessors)))
 	}
 
 	serv := &http.Server{
@@ -117,7 +268,6 @@ func ConfigureAndStartProxyServer(cfg config.Config) {
 
 	log.Debug("Proxying to " + cfg.Proxy.Elasticsearch.Scheme + "://" + cfg.Proxy.Elasticsearch.Host)
 
-	var err error
 	if cfg.Server.IsTlsValid() {
 		if cfg.Server.Tls.UseLetsEncrypt {
 			log.Debug("Listening on " + cfg.Server.Address + " (with TLS using Let's Encrypt)")
@@ -148,11 +298,7 @@ func DetermineRequestType(req *http.Request) int {
 }
 
 func GenerateDefaultFields(requestType int, requestedUrl string, req *http.Request) log.Fields {
-	ip, _, err := net.SplitHostPort(req.RemoteAddr)
-
-	if err != nil {
-		log.Error("Failed to split the host/port on remote address: " + req.RemoteAddr)
-	}
+	ip := GetClientIp(req)
 
 	parsedOrigin, err := url.Parse(req.Header.Get("Origin"))
 	host := ""
@@ -167,6 +313,8 @@ func GenerateDefaultFields(requestType int, requestedUrl string, req *http.Reque
 		"app":     	req.Header.Get("X-App"),
 		"ip":   ip,
 		"userAgent": req.Header.Get("User-Agent"),
+		"requestId": GetTraceContext(req).RequestId,
+		"traceId":   GetTraceContext(req).TraceId,
 		"data": "",
 	}
 }
\ No newline at end of file
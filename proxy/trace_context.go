@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/apex/log"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// A request id and W3C trace context (https://www.w3.org/TR/trace-context/) are accepted from the client or
+// generated, then sent upstream, returned to the client and recorded on the logged events so a front-end
+// search can be matched up with the Elasticsearch request and the analytics document
+
+const traceContextKey contextKey = "traceContext"
+
+const RequestIdHeader = "X-Request-Id"
+const TraceparentHeader = "traceparent"
+
+var requestIdPattern = regexp.MustCompile(`^[A-Za-z0-9._:/+=-]{1,200}$`)
+var traceparentPattern = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})(-.*)?$`)
+
+type TraceContext struct {
+	RequestId string
+	TraceId   string
+
+	// The caller's span, empty when the trace starts at the proxy
+	ParentId string
+
+	// The proxy's own span, sent upstream as the parent
+	SpanId string
+	Flags  string
+}
+
+// NewTraceContext continues the trace from a valid traceparent header or starts a new one
+func NewTraceContext(req *http.Request) TraceContext {
+	traceContext := TraceContext{
+		RequestId: strings.TrimSpace(req.Header.Get(RequestIdHeader)),
+		SpanId:    randomHex(8),
+		Flags:     "01",
+	}
+
+	if !requestIdPattern.MatchString(traceContext.RequestId) {
+		traceContext.RequestId = newRequestId()
+	}
+
+	if traceId, parentId, flags, ok := ParseTraceparent(req.Header.Get(TraceparentHeader)); ok {
+		traceContext.TraceId = traceId
+		traceContext.ParentId = parentId
+		traceContext.Flags = flags
+	} else {
+		traceContext.TraceId = randomHex(16)
+	}
+
+	return traceContext
+}
+
+// ParseTraceparent rejects the all zero ids and the invalid "ff" version, future versions may add fields
+func ParseTraceparent(header string) (traceId string, parentId string, flags string, ok bool) {
+	matches := traceparentPattern.FindStringSubmatch(strings.TrimSpace(header))
+
+	if matches == nil {
+		return "", "", "", false
+	}
+
+	version, traceId, parentId, flags := matches[1], matches[2], matches[3], matches[4]
+
+	if version == "ff" || (version == "00" && matches[5] != "") {
+		return "", "", "", false
+	}
+
+	if traceId == strings.Repeat("0", 32) || parentId == strings.Repeat("0", 16) {
+		return "", "", "", false
+	}
+
+	return traceId, parentId, flags, true
+}
+
+func (tc TraceContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%s", tc.TraceId, tc.SpanId, tc.Flags)
+}
+
+// Used for both the upstream request and the response to the client, tracestate is passed on as it is
+func (tc TraceContext) SetHeaders(header http.Header) {
+	header.Set(RequestIdHeader, tc.RequestId)
+	header.Set(TraceparentHeader, tc.Traceparent())
+}
+
+func WithTraceContext(req *http.Request, traceContext TraceContext) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), traceContextKey, traceContext))
+}
+
+// Empty when the request did not come through the proxy handler, eg. in tests
+func GetTraceContext(req *http.Request) TraceContext {
+	if req == nil {
+		return TraceContext{}
+	}
+
+	traceContext, _ := req.Context().Value(traceContextKey).(TraceContext)
+
+	return traceContext
+}
+
+// RequestLog includes the request and trace ids in the debug logs for a request
+func RequestLog(req *http.Request) *log.Entry {
+	traceContext := GetTraceContext(req)
+
+	return log.WithFields(log.Fields{
+		"requestId": traceContext.RequestId,
+		"traceId":   traceContext.TraceId,
+	})
+}
+
+// The upstream's own headers would be added alongside ours
+func removeUpstreamTraceHeaders(resp *http.Response) {
+	resp.Header.Del(RequestIdHeader)
+	resp.Header.Del(TraceparentHeader)
+}
+
+func newRequestId() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	// Version 4 UUID
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
+
+func randomHex(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
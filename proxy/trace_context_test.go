@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		header string
+		valid  bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			if _, _, _, ok := ParseTraceparent(test.header); ok != test.valid {
+				t.Errorf("expected valid %v", test.valid)
+			}
+		})
+	}
+}
+
+func TestNewTraceContext(t *testing.T) {
+	t.Run("continues the caller's trace", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(RequestIdHeader, "front-end-123")
+		req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+
+		traceContext := NewTraceContext(req)
+
+		if traceContext.RequestId != "front-end-123" || traceContext.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("expected the incoming ids to be kept, got %+v", traceContext)
+		}
+
+		if traceContext.ParentId != "00f067aa0ba902b7" || traceContext.SpanId == traceContext.ParentId {
+			t.Errorf("expected a new span with the caller as the parent, got %+v", traceContext)
+		}
+
+		if expected := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + traceContext.SpanId + "-00"; traceContext.Traceparent() != expected {
+			t.Errorf("expected %s, got %s", expected, traceContext.Traceparent())
+		}
+	})
+
+	t.Run("generates missing and invalid ids", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(RequestIdHeader, "not valid\n")
+		req.Header.Set(TraceparentHeader, "garbage")
+
+		traceContext := NewTraceContext(req)
+
+		if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(traceContext.RequestId) {
+			t.Errorf("expected a generated request id, got %q", traceContext.RequestId)
+		}
+
+		if _, _, _, ok := ParseTraceparent(traceContext.Traceparent()); !ok || traceContext.ParentId != "" {
+			t.Errorf("expected a new trace, got %+v", traceContext)
+		}
+	})
+}
+
+func TestTraceContextPropagation(t *testing.T) {
+	var upstreamHeaders http.Header
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHeaders = r.Header
+		w.Header().Set(RequestIdHeader, "upstream")
+	}))
+	defer upstream.Close()
+
+	target, _ := http.NewRequest("GET", upstream.URL, nil)
+	ctx := NewReverseProxyHandlerContext(target.URL, NewSingleHostReverseProxy(target.URL, nil), nil)
+	handler := NewBasicReverseProxyHandler(&ctx)
+
+	req := httptest.NewRequest("GET", "/properties/_doc/1", nil)
+	req.Header.Set(RequestIdHeader, "front-end-123")
+
+	res := httptest.NewRecorder()
+	handler(res, req)
+
+	if ids := res.Header().Values(RequestIdHeader); len(ids) != 1 || ids[0] != "front-end-123" {
+		t.Errorf("expected the request id to be returned once, got %v", ids)
+	}
+
+	if upstreamHeaders.Get(RequestIdHeader) != "front-end-123" {
+		t.Errorf("expected the request id to be sent upstream, got %q", upstreamHeaders.Get(RequestIdHeader))
+	}
+
+	if upstreamHeaders.Get(TraceparentHeader) == "" || upstreamHeaders.Get(TraceparentHeader) != res.Header().Get(TraceparentHeader) {
+		t.Errorf("expected the same traceparent upstream and in the response, got %q and %q", upstreamHeaders.Get(TraceparentHeader), res.Header().Get(TraceparentHeader))
+	}
+}
@@ -0,0 +1,249 @@
+package proxy
+
+import (
+	"elasticsearch-proxy/elasticsearch"
+	"fmt"
+	"github.com/apex/log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Searches returning no results are the most important signal for the content team, this keeps a rolling
+// count of the filter combinations (location, date range & guests) that led to them
+
+// Bounds memory if the combinations are very spread out, the least recently seen are dropped first
+const zeroResultMaxCombinations = 10000
+
+// Hits are counted per slice of the window rather than kept individually so a popular combination does not
+// grow without bound, the window is accurate to one slice
+const zeroResultBuckets = 60
+
+type ZeroResultCombination struct {
+	Location      string  `json:"location"`
+	ArrivalDate   string  `json:"arrivalDate"`
+	DepartureDate string  `json:"departureDate"`
+	Guests        float64 `json:"guests"`
+}
+
+type ZeroResultCount struct {
+	ZeroResultCombination
+	Count    int       `json:"count"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+type ZeroResultReport struct {
+	Window       string            `json:"window"`
+	Combinations []ZeroResultCount `json:"combinations"`
+}
+
+type ZeroResultTracker struct {
+	Window time.Duration
+	TopN   int
+	Logger *log.Logger
+
+	mu   sync.Mutex
+	seen map[ZeroResultCombination]*zeroResultHistory
+}
+
+type zeroResultHistory struct {
+	buckets  []zeroResultBucket
+	lastSeen time.Time
+}
+
+type zeroResultBucket struct {
+	start time.Time
+	count int
+}
+
+func (h *zeroResultHistory) count() int {
+	count := 0
+
+	for _, bucket := range h.buckets {
+		count += bucket.count
+	}
+
+	return count
+}
+
+func NewZeroResultTracker(window time.Duration, topN int, logger *log.Logger) *ZeroResultTracker {
+	if topN <= 0 {
+		topN = 20
+	}
+
+	return &ZeroResultTracker{
+		Window: window,
+		TopN:   topN,
+		Logger: logger,
+		seen:   make(map[ZeroResultCombination]*zeroResultHistory),
+	}
+}
+
+func IsZeroResultSearch(fields log.Fields) bool {
+	metrics, ok := fields.Get("data").(map[string]interface{})
+
+	if !ok {
+		return false
+	}
+
+	if metric, exists := elasticsearch.FindMetricByName(elasticsearch.MetricResponse, metrics); exists {
+		return metric.(elasticsearch.MetricResponseData).ResultCount == 0
+	}
+
+	return false
+}
+
+func NewZeroResultCombination(metrics map[string]interface{}) ZeroResultCombination {
+	combination := ZeroResultCombination{}
+
+	if metric, exists := elasticsearch.FindMetricByName(elasticsearch.MetricLocation, metrics); exists {
+		location := metric.(elasticsearch.MetricLocationData)
+
+		// Rounded so visitors panning the map slightly still group together
+		combination.Location = fmt.Sprintf("%.2f,%.2f (%s)", location.Latitude, location.Longitude, location.Distance)
+	}
+
+	if metric, exists := elasticsearch.FindMetricByName(elasticsearch.MetricDateRange, metrics); exists {
+		dateRange := metric.(elasticsearch.MetricDateRangeData)
+
+		combination.ArrivalDate = dateRange.ArrivalDate
+		combination.DepartureDate = dateRange.DepartureDate
+	}
+
+	if metric, exists := elasticsearch.FindMetricByName(elasticsearch.MetricGuests, metrics); exists {
+		combination.Guests = metric.(elasticsearch.MetricRangeData).Minimum
+	}
+
+	return combination
+}
+
+// Used as the queue's OnFlush so only the final debounced search of each visitor is counted
+func (t *ZeroResultTracker) Track(fields log.Fields) {
+	if !IsZeroResultSearch(fields) {
+		return
+	}
+
+	t.Add(NewZeroResultCombination(fields.Get("data").(map[string]interface{})), time.Now())
+
+	if t.Logger != nil {
+		t.Logger.WithFields(fields).Info(fmt.Sprintf("%v", fields.Get("url")))
+	}
+}
+
+func (t *ZeroResultTracker) Add(combination ZeroResultCombination, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	history, exists := t.seen[combination]
+
+	if !exists {
+		if len(t.seen) >= zeroResultMaxCombinations {
+			t.evict(at)
+		}
+
+		history = &zeroResultHistory{}
+		t.seen[combination] = history
+	}
+
+	start := at.Truncate(t.bucketWidth())
+	last := len(history.buckets) - 1
+
+	// Anything arriving out of order is counted with the latest bucket
+	if last >= 0 && !history.buckets[last].start.Before(start) {
+		history.buckets[last].count++
+	} else {
+		history.buckets = append(history.buckets, zeroResultBucket{start: start, count: 1})
+	}
+
+	if at.After(history.lastSeen) {
+		history.lastSeen = at
+	}
+}
+
+func (t *ZeroResultTracker) bucketWidth() time.Duration {
+	width := t.Window / zeroResultBuckets
+
+	if width < time.Second {
+		return time.Second
+	}
+
+	return width
+}
+
+// Removes anything outside of the window, if still full then the least recently seen combination is dropped
+func (t *ZeroResultTracker) evict(now time.Time) {
+	t.prune(now)
+
+	if len(t.seen) < zeroResultMaxCombinations {
+		return
+	}
+
+	var oldestKey ZeroResultCombination
+	var oldest time.Time
+
+	for combination, history := range t.seen {
+		if oldest.IsZero() || history.lastSeen.Before(oldest) {
+			oldest = history.lastSeen
+			oldestKey = combination
+		}
+	}
+
+	delete(t.seen, oldestKey)
+}
+
+func (t *ZeroResultTracker) prune(now time.Time) {
+	cutoff := now.Add(-t.Window)
+	width := t.bucketWidth()
+
+	for combination, history := range t.seen {
+		i := 0
+		for i < len(history.buckets) && !history.buckets[i].start.Add(width).After(cutoff) {
+			i++
+		}
+
+		if i == len(history.buckets) {
+			delete(t.seen, combination)
+		} else {
+			history.buckets = history.buckets[i:]
+		}
+	}
+}
+
+func (t *ZeroResultTracker) Report(now time.Time) ZeroResultReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune(now)
+
+	counts := make([]ZeroResultCount, 0, len(t.seen))
+
+	for combination, history := range t.seen {
+		counts = append(counts, ZeroResultCount{
+			ZeroResultCombination: combination,
+			Count:                 history.count(),
+			LastSeen:              history.lastSeen,
+		})
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count == counts[j].Count {
+			return counts[i].LastSeen.After(counts[j].LastSeen)
+		}
+
+		return counts[i].Count > counts[j].Count
+	})
+
+	if len(counts) > t.TopN {
+		counts = counts[:t.TopN]
+	}
+
+	return ZeroResultReport{
+		Window:       t.Window.String(),
+		Combinations: counts,
+	}
+}
+
+func (t *ZeroResultTracker) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	WriteJsonResponse(res, t.Report(time.Now()))
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+var zeroResultsStart = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func TestZeroResultTrackerAdd(t *testing.T) {
+	tracker := NewZeroResultTracker(time.Hour, 0, nil)
+	amsterdam := ZeroResultCombination{Location: "52.37,4.90 (10km)", Guests: 2}
+	paris := ZeroResultCombination{Location: "48.86,2.35 (10km)"}
+
+	tracker.Add(amsterdam, zeroResultsStart)
+	tracker.Add(amsterdam, zeroResultsStart.Add(time.Second))
+	tracker.Add(amsterdam, zeroResultsStart.Add(30*time.Minute))
+	tracker.Add(paris, zeroResultsStart.Add(time.Minute))
+
+	report := tracker.Report(zeroResultsStart.Add(30 * time.Minute))
+
+	if report.Window != "1h0m0s" || len(report.Combinations) != 2 {
+		t.Fatalf("expected both combinations over 1h, got %+v", report)
+	}
+
+	if first := report.Combinations[0]; first.ZeroResultCombination != amsterdam || first.Count != 3 || !first.LastSeen.Equal(zeroResultsStart.Add(30*time.Minute)) {
+		t.Errorf("expected amsterdam 3 times, got %+v", first)
+	}
+
+	if second := report.Combinations[1]; second.ZeroResultCombination != paris || second.Count != 1 {
+		t.Errorf("expected paris once, got %+v", second)
+	}
+}
+
+func TestZeroResultTrackerPrunesOutsideWindow(t *testing.T) {
+	tracker := NewZeroResultTracker(time.Hour, 0, nil)
+	old := ZeroResultCombination{Location: "old"}
+	recent := ZeroResultCombination{Location: "recent"}
+
+	tracker.Add(old, zeroResultsStart)
+	tracker.Add(recent, zeroResultsStart)
+	tracker.Add(recent, zeroResultsStart.Add(50*time.Minute))
+
+	report := tracker.Report(zeroResultsStart.Add(90 * time.Minute))
+
+	if len(report.Combinations) != 1 {
+		t.Fatalf("expected only the recent combination, got %+v", report.Combinations)
+	}
+
+	if combination := report.Combinations[0]; combination.ZeroResultCombination != recent || combination.Count != 1 {
+		t.Errorf("expected the recent combination once, got %+v", combination)
+	}
+
+	if _, exists := tracker.seen[old]; exists {
+		t.Error("expected the old combination to be removed")
+	}
+}
+
+func TestZeroResultTrackerReportOrderAndTopN(t *testing.T) {
+	tracker := NewZeroResultTracker(time.Hour, 2, nil)
+	a := ZeroResultCombination{Location: "a"}
+	b := ZeroResultCombination{Location: "b"}
+	c := ZeroResultCombination{Location: "c"}
+
+	tracker.Add(a, zeroResultsStart)
+	tracker.Add(b, zeroResultsStart.Add(time.Minute))
+	tracker.Add(b, zeroResultsStart.Add(2*time.Minute))
+	tracker.Add(c, zeroResultsStart.Add(3*time.Minute))
+
+	report := tracker.Report(zeroResultsStart.Add(4 * time.Minute))
+
+	if len(report.Combinations) != 2 {
+		t.Fatalf("expected the top 2, got %+v", report.Combinations)
+	}
+
+	// Most results first, ties are broken by the most recently seen
+	if report.Combinations[0].ZeroResultCombination != b || report.Combinations[1].ZeroResultCombination != c {
+		t.Errorf("expected b then c, got %+v", report.Combinations)
+	}
+}
+
+func TestZeroResultTrackerBucketsAreBounded(t *testing.T) {
+	tracker := NewZeroResultTracker(time.Hour, 0, nil)
+	combination := ZeroResultCombination{Location: "popular"}
+
+	for at := zeroResultsStart; at.Before(zeroResultsStart.Add(3 * time.Hour)); at = at.Add(time.Second) {
+		tracker.Add(combination, at)
+	}
+
+	tracker.Report(zeroResultsStart.Add(3 * time.Hour))
+
+	if buckets := len(tracker.seen[combination].buckets); buckets > zeroResultBuckets+1 {
+		t.Errorf("expected at most %d buckets, got %d", zeroResultBuckets+1, buckets)
+	}
+}
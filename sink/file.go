@@ -0,0 +1,199 @@
+package sink
+
+import (
+	"compress/gzip"
+	"elasticsearch-proxy/config"
+	"elasticsearch-proxy/util"
+	"fmt"
+	"github.com/apex/log"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileSink appends NDJSON to a file which is rotated once it reaches the max size or has been open for the rotate
+// interval, the closed files are renamed with a timestamp and optionally gzipped in the background
+type FileSink struct {
+	Path           string
+	MaxSize        int64
+	RotateInterval time.Duration
+	Compress       bool
+
+	mu          sync.Mutex
+	file        *os.File
+	size        int64
+	openedAt    time.Time
+	compressing sync.WaitGroup
+}
+
+func NewFileSink(cfg config.SinkConfig) (*FileSink, error) {
+	sink := &FileSink{
+		Path:           cfg.Path,
+		MaxSize:        int64(cfg.MaxSizeMb) * 1024 * 1024,
+		RotateInterval: cfg.ParseRotateInterval(),
+		Compress:       cfg.Compress,
+	}
+
+	if err := os.MkdirAll(filepath.Dir(sink.Path), 0755); err != nil {
+		return nil, err
+	}
+
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+
+	return sink, nil
+}
+
+func (s *FileSink) HandleLog(entry *log.Entry) error {
+	line, err := encodeEntry(entry)
+
+	if err != nil {
+		return err
+	}
+
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shouldRotate(int64(len(line))) {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	written, err := s.file.Write(line)
+	s.size += int64(written)
+
+	return err
+}
+
+// Close stops writing and waits for any rotated files to finish compressing
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	err := s.file.Close()
+	s.mu.Unlock()
+
+	s.compressing.Wait()
+
+	return err
+}
+
+func (s *FileSink) shouldRotate(nextWrite int64) bool {
+	if s.size == 0 {
+		return false
+	}
+
+	if s.MaxSize > 0 && s.size+nextWrite > s.MaxSize {
+		return true
+	}
+
+	return s.RotateInterval > 0 && time.Since(s.openedAt) >= s.RotateInterval
+}
+
+func (s *FileSink) open() error {
+	file, err := os.OpenFile(s.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+
+	if err != nil {
+		file.Close()
+
+		return err
+	}
+
+	s.file = file
+	s.size = info.Size()
+	s.openedAt = time.Now()
+
+	return nil
+}
+
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+
+	rotated := s.rotatedPath(time.Now())
+
+	if err := os.Rename(s.Path, rotated); err != nil {
+		return err
+	}
+
+	if s.Compress {
+		s.compressing.Add(1)
+
+		go func() {
+			defer s.compressing.Done()
+
+			if err := compressFile(rotated); err != nil {
+				log.WithField("file", rotated).WithField("error", err.Error()).Error(util.LogMsg("Could not compress rotated sink file"))
+			}
+		}()
+	}
+
+	return s.open()
+}
+
+// eg. queries.ndjson becomes queries-20200102T030405.000.ndjson
+func (s *FileSink) rotatedPath(now time.Time) string {
+	ext := filepath.Ext(s.Path)
+	base := strings.TrimSuffix(s.Path, ext)
+	rotated := fmt.Sprintf("%s-%s%s", base, now.UTC().Format("20060102T150405.000"), ext)
+
+	for i := 1; fileExists(rotated) || fileExists(rotated+".gz"); i++ {
+		rotated = fmt.Sprintf("%s-%s-%d%s", base, now.UTC().Format("20060102T150405.000"), i, ext)
+	}
+
+	return rotated
+}
+
+func compressFile(path string) error {
+	source, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer source.Close()
+
+	target, err := os.Create(path + ".gz")
+
+	if err != nil {
+		return err
+	}
+
+	writer := gzip.NewWriter(target)
+
+	if _, err := io.Copy(writer, source); err != nil {
+		target.Close()
+
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		target.Close()
+
+		return err
+	}
+
+	if err := target.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+
+	return err == nil
+}
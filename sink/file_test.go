@@ -0,0 +1,143 @@
+package sink
+
+import (
+	"bufio"
+	"compress/gzip"
+	"elasticsearch-proxy/config"
+	"github.com/apex/log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileSink(t *testing.T) {
+	t.Run("rotates on size and compresses the closed file", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "queries.ndjson")
+
+		sink, err := NewFileSink(config.SinkConfig{Type: config.SinkFile, Path: path, Compress: true})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Small enough that every entry after the first triggers a rotation
+		sink.MaxSize = 10
+
+		for _, url := range []string{"/first", "/second", "/third"} {
+			if err := sink.HandleLog(testEntry(url)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		rotated, _ := filepath.Glob(filepath.Join(dir, "queries-*.ndjson.gz"))
+		if len(rotated) != 2 {
+			t.Fatalf("expected 2 compressed files, got %v", rotated)
+		}
+
+		uncompressed, _ := filepath.Glob(filepath.Join(dir, "queries-*.ndjson"))
+		if len(uncompressed) != 0 {
+			t.Errorf("expected the rotated files to be removed once compressed, got %v", uncompressed)
+		}
+
+		lines := readLines(t, rotated[0], true)
+		lines = append(lines, readLines(t, rotated[1], true)...)
+		lines = append(lines, readLines(t, path, false)...)
+
+		if len(lines) != 3 {
+			t.Fatalf("expected 3 entries across the files, got %d", len(lines))
+		}
+
+		if !strings.Contains(readLines(t, path, false)[0], `"url":"/third"`) {
+			t.Errorf("expected the newest entry in the active file, got %v", readLines(t, path, false))
+		}
+	})
+
+	t.Run("rotates on interval", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "queries.ndjson")
+
+		sink, err := NewFileSink(config.SinkConfig{Type: config.SinkFile, Path: path, RotateInterval: "1h"})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sink.HandleLog(testEntry("/first"))
+		sink.openedAt = time.Now().Add(-2 * time.Hour)
+		sink.HandleLog(testEntry("/second"))
+		sink.Close()
+
+		rotated, _ := filepath.Glob(filepath.Join(dir, "queries-*.ndjson"))
+		if len(rotated) != 1 {
+			t.Fatalf("expected 1 rotated file, got %v", rotated)
+		}
+
+		if lines := readLines(t, path, false); len(lines) != 1 {
+			t.Errorf("expected 1 entry in the active file, got %d", len(lines))
+		}
+	})
+
+	t.Run("appends to an existing file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "queries.ndjson")
+
+		for i := 0; i < 2; i++ {
+			sink, err := NewFileSink(config.SinkConfig{Type: config.SinkFile, Path: path})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sink.HandleLog(testEntry("/search"))
+			sink.Close()
+		}
+
+		if lines := readLines(t, path, false); len(lines) != 2 {
+			t.Errorf("expected 2 entries, got %d", len(lines))
+		}
+	})
+}
+
+func testEntry(url string) *log.Entry {
+	return &log.Entry{
+		Fields:    log.Fields{"type": "ELASTICSEARCH"},
+		Level:     log.InfoLevel,
+		Timestamp: time.Now(),
+		Message:   url,
+	}
+}
+
+func readLines(t *testing.T, path string, compressed bool) []string {
+	file, err := os.Open(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	if compressed {
+		reader, err := gzip.NewReader(file)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		scanner = bufio.NewScanner(reader)
+	}
+
+	lines := make([]string, 0)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	return lines
+}
@@ -0,0 +1,231 @@
+package sink
+
+import (
+	"elasticsearch-proxy/config"
+	"elasticsearch-proxy/util"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/apex/log"
+	"sync"
+	"time"
+)
+
+// Messages waiting for the producer, further entries are dropped while Kafka is unreachable or cannot keep up
+const kafkaQueueSize = 1000
+
+// Connecting is retried in the background with a backoff up to the maximum
+const kafkaConnectBackoff = 5 * time.Second
+const kafkaMaxConnectBackoff = time.Minute
+
+// KafkaSink produces each entry to a topic, keyed by one of its fields (the visitor IP by default) so the hash
+// partitioner keeps a visitor's events in order. Batching and retries are handled by the async producer.
+// Entries are queued without blocking the queue, the producer is connected in the background so the proxy
+// still starts when Kafka is down
+type KafkaSink struct {
+	Topic    string
+	KeyField string
+
+	// Nil until connected
+	Producer sarama.AsyncProducer
+
+	mu        sync.Mutex
+	closed    bool
+	messages  chan *sarama.ProducerMessage
+	done      chan struct{}
+	forwarded chan struct{}
+}
+
+func NewKafkaSink(cfg config.SinkConfig) (*KafkaSink, error) {
+	producerConfig, err := NewKafkaProducerConfig(cfg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sink := newKafkaSink(cfg)
+
+	go sink.connect(cfg.Brokers, producerConfig)
+
+	return sink, nil
+}
+
+// Used directly by the tests with a mock producer
+func NewKafkaSinkWithProducer(cfg config.SinkConfig, producer sarama.AsyncProducer) *KafkaSink {
+	sink := newKafkaSink(cfg)
+	sink.Producer = producer
+
+	go sink.start(producer)
+
+	return sink
+}
+
+func newKafkaSink(cfg config.SinkConfig) *KafkaSink {
+	sink := &KafkaSink{
+		Topic:     cfg.Topic,
+		KeyField:  cfg.KeyField,
+		messages:  make(chan *sarama.ProducerMessage, kafkaQueueSize),
+		done:      make(chan struct{}),
+		forwarded: make(chan struct{}),
+	}
+
+	if sink.KeyField == "" {
+		sink.KeyField = "ip"
+	}
+
+	return sink
+}
+
+func NewKafkaProducerConfig(cfg config.SinkConfig) (*sarama.Config, error) {
+	producerConfig := sarama.NewConfig()
+	producerConfig.ClientID = "zazu"
+	producerConfig.Producer.Return.Errors = true
+	producerConfig.Producer.Flush.Frequency = cfg.ParseFlushInterval()
+	producerConfig.Producer.Flush.Messages = cfg.BatchSize
+
+	if producerConfig.Producer.Flush.Messages == 0 {
+		producerConfig.Producer.Flush.Messages = 100
+	}
+
+	producerConfig.Producer.Retry.Max = cfg.ParseMaxRetries()
+
+	switch cfg.Compression {
+	case "", "none":
+		producerConfig.Producer.Compression = sarama.CompressionNone
+	case "gzip":
+		producerConfig.Producer.Compression = sarama.CompressionGZIP
+	case "snappy":
+		producerConfig.Producer.Compression = sarama.CompressionSnappy
+	case "lz4":
+		producerConfig.Producer.Compression = sarama.CompressionLZ4
+	case "zstd":
+		// Only supported by the newer protocol versions
+		producerConfig.Producer.Compression = sarama.CompressionZSTD
+		producerConfig.Version = sarama.V2_1_0_0
+	default:
+		return nil, fmt.Errorf("unknown kafka compression %q", cfg.Compression)
+	}
+
+	switch cfg.RequiredAcks {
+	case "", "leader":
+		producerConfig.Producer.RequiredAcks = sarama.WaitForLocal
+	case "none":
+		producerConfig.Producer.RequiredAcks = sarama.NoResponse
+	case "all":
+		producerConfig.Producer.RequiredAcks = sarama.WaitForAll
+	default:
+		return nil, fmt.Errorf("unknown kafka required acks %q", cfg.RequiredAcks)
+	}
+
+	if cfg.TlsEnabled {
+		tlsConfig, err := cfg.Tls.BuildTlsConfig()
+
+		if err != nil {
+			return nil, err
+		}
+
+		producerConfig.Net.TLS.Enable = true
+		producerConfig.Net.TLS.Config = tlsConfig
+	}
+
+	return producerConfig, producerConfig.Validate()
+}
+
+func (s *KafkaSink) HandleLog(entry *log.Entry) error {
+	value, err := encodeEntry(entry)
+
+	if err != nil {
+		return err
+	}
+
+	message := &sarama.ProducerMessage{
+		Topic: s.Topic,
+		Value: sarama.ByteEncoder(value),
+	}
+
+	if key := entry.Fields.Get(s.KeyField); key != nil {
+		message.Key = sarama.StringEncoder(fmt.Sprintf("%v", key))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("kafka sink is closed")
+	}
+
+	select {
+	case s.messages <- message:
+	default:
+		log.WithField("topic", s.Topic).Error(util.LogMsg("Kafka sink queue is full, dropped entry"))
+	}
+
+	return nil
+}
+
+// Close flushes the queued and buffered messages, anything queued while Kafka was never reachable is dropped
+func (s *KafkaSink) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	close(s.messages)
+	s.mu.Unlock()
+
+	close(s.done)
+	<-s.forwarded
+
+	if s.Producer == nil {
+		if dropped := len(s.messages); dropped > 0 {
+			log.WithField("topic", s.Topic).WithField("entries", dropped).Error(util.LogMsg("Kafka sink was never connected, dropped entries"))
+		}
+
+		return nil
+	}
+
+	return s.Producer.Close()
+}
+
+func (s *KafkaSink) connect(brokers []string, producerConfig *sarama.Config) {
+	backoff := kafkaConnectBackoff
+
+	for {
+		producer, err := sarama.NewAsyncProducer(brokers, producerConfig)
+
+		if err == nil {
+			s.Producer = producer
+			s.start(producer)
+
+			return
+		}
+
+		log.WithField("topic", s.Topic).WithField("error", err.Error()).Error(util.LogMsg("Kafka sink could not connect, retrying in " + backoff.String()))
+
+		select {
+		case <-time.After(backoff):
+		case <-s.done:
+			close(s.forwarded)
+
+			return
+		}
+
+		if backoff *= 2; backoff > kafkaMaxConnectBackoff {
+			backoff = kafkaMaxConnectBackoff
+		}
+	}
+}
+
+// Forwards the queued messages until the sink is closed, the producer batches them
+func (s *KafkaSink) start(producer sarama.AsyncProducer) {
+	defer close(s.forwarded)
+
+	go s.logErrors(producer)
+
+	for message := range s.messages {
+		producer.Input() <- message
+	}
+}
+
+// The producer only reports failures once the retries are used up
+func (s *KafkaSink) logErrors(producer sarama.AsyncProducer) {
+	for err := range producer.Errors() {
+		log.WithField("topic", s.Topic).WithField("error", err.Error()).Error(util.LogMsg("Kafka sink dropped entry"))
+	}
+}
@@ -0,0 +1,165 @@
+package sink
+
+import (
+	"elasticsearch-proxy/config"
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/apex/log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKafkaSink(t *testing.T) {
+	t.Run("keys messages by the visitor", func(t *testing.T) {
+		producerConfig := sarama.NewConfig()
+		producerConfig.Producer.Return.Successes = true
+
+		producer := mocks.NewAsyncProducer(t, producerConfig)
+		producer.ExpectInputAndSucceed()
+		producer.ExpectInputAndSucceed()
+
+		sink := NewKafkaSinkWithProducer(config.SinkConfig{Type: config.SinkKafka, Topic: "searches"}, producer)
+
+		entry := testEntry("/search")
+		entry.Fields["ip"] = "192.0.2.1"
+		sink.HandleLog(entry)
+
+		// Without the key field the partition is picked at random
+		sink.HandleLog(testEntry("/search"))
+
+		keyed := <-producer.Successes()
+		unkeyed := <-producer.Successes()
+
+		if keyed.Topic != "searches" {
+			t.Errorf("expected topic searches, got %q", keyed.Topic)
+		}
+
+		if key, _ := keyed.Key.Encode(); string(key) != "192.0.2.1" {
+			t.Errorf("expected the ip as key, got %q", key)
+		}
+
+		if value, _ := keyed.Value.Encode(); !strings.Contains(string(value), `"url":"/search"`) {
+			t.Errorf("expected the encoded entry, got %s", value)
+		}
+
+		if unkeyed.Key != nil {
+			t.Errorf("expected no key, got %v", unkeyed.Key)
+		}
+
+		if err := sink.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("produces batches to a broker", func(t *testing.T) {
+		broker := sarama.NewMockBroker(t, 1)
+		defer broker.Close()
+
+		broker.SetHandlerByMap(map[string]sarama.MockResponse{
+			"MetadataRequest": sarama.NewMockMetadataResponse(t).
+				SetBroker(broker.Addr(), broker.BrokerID()).
+				SetLeader("searches", 0, broker.BrokerID()),
+			"ProduceRequest": sarama.NewMockProduceResponse(t),
+		})
+
+		sink, err := NewKafkaSink(config.SinkConfig{
+			Type:          config.SinkKafka,
+			Brokers:       []string{broker.Addr()},
+			Topic:         "searches",
+			BatchSize:     3,
+			FlushInterval: "1h",
+			Compression:   "gzip",
+			RequiredAcks:  "all",
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 3; i++ {
+			entry := testEntry("/search")
+			entry.Fields = log.Fields{"ip": "192.0.2.1"}
+			sink.HandleLog(entry)
+		}
+
+		waitFor(t, func() bool {
+			return len(produceRequests(broker)) > 0
+		})
+
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		requests := produceRequests(broker)
+
+		if len(requests) != 1 {
+			t.Fatalf("expected the entries in a single produce request, got %d", len(requests))
+		}
+
+		if requests[0].RequiredAcks != sarama.WaitForAll {
+			t.Errorf("expected acks from all replicas, got %v", requests[0].RequiredAcks)
+		}
+	})
+
+	t.Run("starts and drops entries while kafka is unreachable", func(t *testing.T) {
+		// Nothing listens on the port, connecting is retried in the background
+		sink, err := NewKafkaSink(config.SinkConfig{Type: config.SinkKafka, Brokers: []string{"127.0.0.1:1"}, Topic: "searches"})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		finished := make(chan struct{})
+
+		go func() {
+			for i := 0; i < kafkaQueueSize+5; i++ {
+				sink.HandleLog(testEntry("/search"))
+			}
+
+			close(finished)
+		}()
+
+		select {
+		case <-finished:
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected HandleLog not to block while kafka is unreachable")
+		}
+
+		if err := sink.Close(); err != nil {
+			t.Error(err)
+		}
+
+		if err := sink.HandleLog(testEntry("/search")); err == nil {
+			t.Error("expected an error once closed")
+		}
+	})
+
+	t.Run("raises the protocol version for zstd", func(t *testing.T) {
+		producerConfig, err := NewKafkaProducerConfig(config.SinkConfig{Compression: "zstd"})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !producerConfig.Version.IsAtLeast(sarama.V2_1_0_0) {
+			t.Errorf("expected the protocol version to be raised for zstd, got %v", producerConfig.Version)
+		}
+
+		if _, err := NewKafkaProducerConfig(config.SinkConfig{RequiredAcks: "some"}); err == nil {
+			t.Error("expected an error for unknown acks")
+		}
+	})
+}
+
+func produceRequests(broker *sarama.MockBroker) []*sarama.ProduceRequest {
+	requests := make([]*sarama.ProduceRequest, 0)
+
+	for _, rr := range broker.History() {
+		if request, ok := rr.Request.(*sarama.ProduceRequest); ok {
+			requests = append(requests, request)
+		}
+	}
+
+	return requests
+}
@@ -0,0 +1,112 @@
+package sink
+
+import (
+	"elasticsearch-proxy/config"
+	"elasticsearch-proxy/event"
+	"fmt"
+	"github.com/apex/log"
+	"io"
+	"strings"
+)
+
+// Sink receives the final debounced entries of a queue. It has the same shape as an apex log.Handler so it is set
+// as the handler of the queue's logger, the Elasticsearch bulk Handler is a sink as well
+type Sink interface {
+	HandleLog(entry *log.Entry) error
+}
+
+// MultiSink fans each entry out to every sink, a failing sink does not stop the others from receiving it
+type MultiSink []Sink
+
+func (m MultiSink) HandleLog(entry *log.Entry) error {
+	errors := make([]string, 0)
+
+	for _, sink := range m {
+		if err := sink.HandleLog(entry); err != nil {
+			errors = append(errors, err.Error())
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("%d sink(s) failed: %s", len(errors), strings.Join(errors, "; "))
+	}
+
+	return nil
+}
+
+// Close flushes and closes the sinks that buffer or hold resources (file, webhook and kafka), others are left as
+// they are
+func Close(s Sink) error {
+	switch sink := s.(type) {
+	case MultiSink:
+		errors := make([]string, 0)
+
+		for _, member := range sink {
+			if err := Close(member); err != nil {
+				errors = append(errors, err.Error())
+			}
+		}
+
+		if len(errors) > 0 {
+			return fmt.Errorf("%d sink(s) failed to close: %s", len(errors), strings.Join(errors, "; "))
+		}
+	case io.Closer:
+		return sink.Close()
+	}
+
+	return nil
+}
+
+// New builds the sinks configured for a queue, the elasticsearch sink is the queue's bulk Handler which is used on
+// its own when nothing else is configured
+func New(configs []config.SinkConfig, elasticsearch Sink) (Sink, error) {
+	if len(configs) == 0 {
+		return elasticsearch, nil
+	}
+
+	sinks := make(MultiSink, 0, len(configs))
+
+	for _, cfg := range configs {
+		switch cfg.Type {
+		case config.SinkElasticsearch:
+			if elasticsearch == nil {
+				return nil, fmt.Errorf("elasticsearch sink configured without an index")
+			}
+
+			sinks = append(sinks, elasticsearch)
+		case config.SinkFile:
+			file, err := NewFileSink(cfg)
+
+			if err != nil {
+				return nil, err
+			}
+
+			sinks = append(sinks, file)
+		case config.SinkStdout:
+			sinks = append(sinks, NewStdoutSink())
+		case config.SinkWebhook:
+			sinks = append(sinks, NewWebhookSink(cfg))
+		case config.SinkKafka:
+			kafka, err := NewKafkaSink(cfg)
+
+			if err != nil {
+				return nil, err
+			}
+
+			sinks = append(sinks, kafka)
+		default:
+			return nil, fmt.Errorf("unknown sink type %q", cfg.Type)
+		}
+	}
+
+	if len(sinks) == 1 {
+		return sinks[0], nil
+	}
+
+	return sinks, nil
+}
+
+// Entries are written as the same typed events that are indexed in Elasticsearch
+func encodeEntry(entry *log.Entry) ([]byte, error) {
+	return event.Marshal(entry)
+}
@@ -0,0 +1,34 @@
+package sink
+
+import (
+	"github.com/apex/log"
+	"io"
+	"os"
+	"sync"
+)
+
+// WriterSink writes each entry as a single JSON line, on stdout this is picked up by container log shipping
+type WriterSink struct {
+	Writer io.Writer
+
+	mu sync.Mutex
+}
+
+func NewStdoutSink() *WriterSink {
+	return &WriterSink{Writer: os.Stdout}
+}
+
+func (s *WriterSink) HandleLog(entry *log.Entry) error {
+	line, err := encodeEntry(entry)
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.Writer.Write(append(line, '\n'))
+
+	return err
+}
@@ -0,0 +1,205 @@
+package sink
+
+import (
+	"bytes"
+	"elasticsearch-proxy/config"
+	"elasticsearch-proxy/util"
+	"fmt"
+	"github.com/apex/log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Batches waiting for the sender, further batches are dropped when the webhook cannot keep up
+const webhookQueueSize = 10
+
+// WebhookSink POSTs the entries as a JSON array once the batch is full or the flush interval passes, failed
+// requests (connection errors, 429 and 5xx) are retried with an exponential backoff before the batch is dropped.
+// Batches are sent one at a time in the background so a slow webhook never blocks the queue
+type WebhookSink struct {
+	Url           string
+	Headers       map[string]string
+	BatchSize     int
+	FlushInterval time.Duration
+	MaxRetries    int
+	RetryBackoff  time.Duration
+	Client        *http.Client
+
+	mu     sync.Mutex
+	batch  [][]byte
+	closed bool
+	queue  chan [][]byte
+	done   chan struct{}
+	sent   chan struct{}
+}
+
+func NewWebhookSink(cfg config.SinkConfig) *WebhookSink {
+	sink := &WebhookSink{
+		Url:           cfg.Url,
+		Headers:       cfg.Headers,
+		BatchSize:     cfg.BatchSize,
+		FlushInterval: cfg.ParseFlushInterval(),
+		MaxRetries:    cfg.ParseMaxRetries(),
+		RetryBackoff:  time.Second,
+		Client:        &http.Client{Timeout: 30 * time.Second},
+		queue:         make(chan [][]byte, webhookQueueSize),
+		done:          make(chan struct{}),
+		sent:          make(chan struct{}),
+	}
+
+	if sink.BatchSize == 0 {
+		sink.BatchSize = 100
+	}
+
+	go sink.sendQueued()
+	go sink.flushOnInterval()
+
+	return sink
+}
+
+func (s *WebhookSink) HandleLog(entry *log.Entry) error {
+	line, err := encodeEntry(entry)
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("webhook sink is closed")
+	}
+
+	s.batch = append(s.batch, line)
+
+	if len(s.batch) >= s.BatchSize {
+		s.enqueue(s.takeBatch())
+	}
+
+	return nil
+}
+
+// Close stops the interval flush, waits for the queued batches and then sends whatever is left in the batch
+func (s *WebhookSink) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	batch := s.takeBatch()
+	close(s.queue)
+	s.mu.Unlock()
+
+	close(s.done)
+	<-s.sent
+
+	return s.send(batch)
+}
+
+func (s *WebhookSink) sendQueued() {
+	defer close(s.sent)
+
+	for batch := range s.queue {
+		s.send(batch)
+	}
+}
+
+func (s *WebhookSink) flushOnInterval() {
+	ticker := time.NewTicker(s.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			if !s.closed {
+				s.enqueue(s.takeBatch())
+			}
+			s.mu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Must be called with the lock held, never blocks
+func (s *WebhookSink) enqueue(batch [][]byte) {
+	if len(batch) == 0 {
+		return
+	}
+
+	select {
+	case s.queue <- batch:
+	default:
+		log.WithField("entries", len(batch)).Error(util.LogMsg("Webhook sink queue is full, dropped batch"))
+	}
+}
+
+// Must be called with the lock held
+func (s *WebhookSink) takeBatch() [][]byte {
+	batch := s.batch
+	s.batch = nil
+
+	return batch
+}
+
+func (s *WebhookSink) send(batch [][]byte) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	body := append([]byte{'['}, bytes.Join(batch, []byte{','})...)
+	body = append(body, ']')
+
+	var err error
+	backoff := s.RetryBackoff
+
+	for attempt := 0; attempt <= s.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		var retry bool
+		retry, err = s.post(body)
+
+		if err == nil || !retry {
+			break
+		}
+	}
+
+	if err != nil {
+		log.WithField("entries", len(batch)).WithField("error", err.Error()).Error(util.LogMsg("Webhook sink dropped batch"))
+	}
+
+	return err
+}
+
+func (s *WebhookSink) post(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", s.Url, bytes.NewReader(body))
+
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	for name, value := range s.Headers {
+		req.Header.Set(name, value)
+	}
+
+	res, err := s.Client.Do(req)
+
+	if err != nil {
+		return true, err
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+
+	return retry, fmt.Errorf("webhook responded with %s", res.Status)
+}
@@ -0,0 +1,208 @@
+package sink
+
+import (
+	"elasticsearch-proxy/config"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookSink(t *testing.T) {
+	t.Run("posts full batches and retries server errors", func(t *testing.T) {
+		var mu sync.Mutex
+		attempts := 0
+		received := make([][]map[string]interface{}, 0)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			attempts++
+
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				t.Errorf("expected the configured headers, got %v", r.Header)
+			}
+
+			if attempts == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+
+				return
+			}
+
+			body, _ := ioutil.ReadAll(r.Body)
+
+			var batch []map[string]interface{}
+			if err := json.Unmarshal(body, &batch); err != nil {
+				t.Errorf("expected a JSON array, got %s", body)
+			}
+
+			received = append(received, batch)
+		}))
+		defer server.Close()
+
+		sink := NewWebhookSink(config.SinkConfig{
+			Type:          config.SinkWebhook,
+			Url:           server.URL,
+			Headers:       map[string]string{"Authorization": "Bearer secret"},
+			BatchSize:     2,
+			FlushInterval: "1h",
+		})
+		sink.RetryBackoff = time.Millisecond
+
+		sink.HandleLog(testEntry("/first"))
+		sink.HandleLog(testEntry("/second"))
+		sink.HandleLog(testEntry("/third"))
+
+		// The full batch is sent in the background, the remainder on close
+		waitFor(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+
+			return len(received) == 1
+		})
+
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if attempts != 3 {
+			t.Errorf("expected 3 attempts (1 retry), got %d", attempts)
+		}
+
+		if len(received) != 2 || len(received[0]) != 2 || len(received[1]) != 1 {
+			t.Fatalf("expected batches of 2 and 1, got %v", received)
+		}
+
+		if received[1][0]["url"] != "/third" {
+			t.Errorf("expected the last entry in the final batch, got %v", received[1][0])
+		}
+	})
+
+	t.Run("does not retry client errors", func(t *testing.T) {
+		attempts := 0
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer server.Close()
+
+		sink := NewWebhookSink(config.SinkConfig{Type: config.SinkWebhook, Url: server.URL, FlushInterval: "1h"})
+		sink.RetryBackoff = time.Millisecond
+
+		sink.HandleLog(testEntry("/first"))
+
+		if err := sink.Close(); err == nil {
+			t.Error("expected the rejected batch to be reported")
+		}
+
+		if attempts != 1 {
+			t.Errorf("expected a single attempt, got %d", attempts)
+		}
+	})
+
+	t.Run("retries can be turned off", func(t *testing.T) {
+		attempts := 0
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		maxRetries := 0
+		sink := NewWebhookSink(config.SinkConfig{Type: config.SinkWebhook, Url: server.URL, FlushInterval: "1h", MaxRetries: &maxRetries})
+		sink.RetryBackoff = time.Millisecond
+
+		sink.HandleLog(testEntry("/first"))
+
+		if err := sink.Close(); err == nil {
+			t.Error("expected the failed batch to be reported")
+		}
+
+		if attempts != 1 {
+			t.Errorf("expected a single attempt, got %d", attempts)
+		}
+	})
+
+	t.Run("rejects entries once closed", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer server.Close()
+
+		sink := NewWebhookSink(config.SinkConfig{Type: config.SinkWebhook, Url: server.URL, BatchSize: 1, FlushInterval: "1h"})
+
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := sink.HandleLog(testEntry("/late")); err == nil {
+			t.Error("expected an error once closed")
+		}
+	})
+
+	t.Run("drops batches instead of blocking when the webhook is slow", func(t *testing.T) {
+		release := make(chan struct{})
+		var mu sync.Mutex
+		received := 0
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+
+			mu.Lock()
+			received++
+			mu.Unlock()
+		}))
+		defer server.Close()
+
+		sink := NewWebhookSink(config.SinkConfig{Type: config.SinkWebhook, Url: server.URL, BatchSize: 1, FlushInterval: "1h"})
+
+		// One batch is being sent, the queue fills up and the rest are dropped
+		finished := make(chan struct{})
+
+		go func() {
+			for i := 0; i < webhookQueueSize+5; i++ {
+				sink.HandleLog(testEntry("/search"))
+			}
+
+			close(finished)
+		}()
+
+		select {
+		case <-finished:
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected HandleLog not to block on a slow webhook")
+		}
+
+		close(release)
+
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if received < webhookQueueSize || received > webhookQueueSize+1 {
+			t.Errorf("expected the queued batches to be sent and the rest dropped, got %d", received)
+		}
+	})
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,154 @@
+package useragent
+
+import (
+	"fmt"
+	"github.com/samvaughton/crawlerdetection"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+const DeviceDesktop = "desktop"
+const DeviceMobile = "mobile"
+const DeviceTablet = "tablet"
+const DeviceBot = "bot"
+
+type Rules struct {
+	Browsers         []Rule `yaml:"browsers"`
+	OperatingSystems []Rule `yaml:"os"`
+	Devices          []Rule `yaml:"devices"`
+}
+
+type Rule struct {
+	Regex   string `yaml:"regex"`
+	Exclude string `yaml:"exclude"`
+	Family  string `yaml:"family"`
+	Version string `yaml:"version"`
+	Type    string `yaml:"type"`
+
+	regex   *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+type UserAgent struct {
+	Browser Software `json:"browser"`
+	Os      Software `json:"os"`
+	Device  Device   `json:"device"`
+}
+
+type Software struct {
+	Family  string `json:"family"`
+	Version string `json:"version"`
+}
+
+type Device struct {
+	Type string `json:"type"`
+}
+
+type Parser struct {
+	Rules Rules
+}
+
+func LoadParserFromFile(name string) (*Parser, error) {
+	content, err := ioutil.ReadFile(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewParser(content)
+}
+
+func NewParser(content []byte) (*Parser, error) {
+	rules := Rules{}
+
+	if err := yaml.Unmarshal(content, &rules); err != nil {
+		return nil, err
+	}
+
+	for _, list := range [][]Rule{rules.Browsers, rules.OperatingSystems, rules.Devices} {
+		for i := range list {
+			if err := list[i].compile(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &Parser{Rules: rules}, nil
+}
+
+func (r *Rule) compile() error {
+	var err error
+
+	if r.regex, err = regexp.Compile(r.Regex); err != nil {
+		return fmt.Errorf("invalid user agent rule %q: %v", r.Regex, err)
+	}
+
+	if r.Exclude != "" {
+		if r.exclude, err = regexp.Compile(r.Exclude); err != nil {
+			return fmt.Errorf("invalid user agent exclude %q: %v", r.Exclude, err)
+		}
+	}
+
+	return nil
+}
+
+// Returns the first capture group (if any) when the rule matches
+func (r *Rule) match(userAgent string) (bool, string) {
+	if r.exclude != nil && r.exclude.MatchString(userAgent) {
+		return false, ""
+	}
+
+	matches := r.regex.FindStringSubmatch(userAgent)
+
+	if matches == nil {
+		return false, ""
+	}
+
+	if len(matches) > 1 {
+		return true, matches[1]
+	}
+
+	return true, ""
+}
+
+func (p *Parser) Parse(userAgent string) UserAgent {
+	return UserAgent{
+		Browser: matchSoftware(p.Rules.Browsers, userAgent),
+		Os:      matchSoftware(p.Rules.OperatingSystems, userAgent),
+		Device:  Device{Type: p.matchDeviceType(userAgent)},
+	}
+}
+
+func matchSoftware(rules []Rule, userAgent string) Software {
+	for i := range rules {
+		if matched, captured := rules[i].match(userAgent); matched {
+			version := rules[i].Version
+
+			if version == "" {
+				// iOS and macOS use underscores in their versions
+				version = strings.Replace(captured, "_", ".", -1)
+			}
+
+			return Software{Family: rules[i].Family, Version: version}
+		}
+	}
+
+	return Software{Family: "Other"}
+}
+
+func (p *Parser) matchDeviceType(userAgent string) string {
+	// The crawler list is far more complete than anything we would maintain in the rules
+	if crawlerdetection.IsCrawler(userAgent) {
+		return DeviceBot
+	}
+
+	for i := range p.Rules.Devices {
+		if matched, _ := p.Rules.Devices[i].match(userAgent); matched {
+			return p.Rules.Devices[i].Type
+		}
+	}
+
+	return DeviceDesktop
+}
@@ -0,0 +1,75 @@
+package useragent
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	parser, err := LoadParserFromFile("../user_agent_rules.yml")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		userAgent string
+		browser   Software
+		os        Software
+		device    string
+	}{
+		{
+			"chrome on windows",
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/83.0.4103.116 Safari/537.36",
+			Software{"Chrome", "83.0.4103.116"}, Software{"Windows", "10"}, DeviceDesktop,
+		},
+		{
+			"safari on iphone",
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 13_5_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.1.1 Mobile/15E148 Safari/604.1",
+			Software{"Safari", "13.1.1"}, Software{"iOS", "13.5.1"}, DeviceMobile,
+		},
+		{
+			"android tablet",
+			"Mozilla/5.0 (Linux; Android 9; SM-T720) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/83.0.4103.106 Safari/537.36",
+			Software{"Chrome", "83.0.4103.106"}, Software{"Android", "9"}, DeviceTablet,
+		},
+		{
+			"edge is not chrome",
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/83.0.4103.97 Safari/537.36 Edg/83.0.478.45",
+			Software{"Edge", "83.0.478.45"}, Software{"Windows", "10"}, DeviceDesktop,
+		},
+		{
+			"googlebot",
+			"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			Software{"Bot", "2.1"}, Software{"Other", ""}, DeviceBot,
+		},
+		{
+			"bingbot",
+			"Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)",
+			Software{"Bot", "2.0"}, Software{"Other", ""}, DeviceBot,
+		},
+		{
+			"cubot phone is not a bot",
+			"Mozilla/5.0 (Linux; Android 9; CUBOT_X19) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/83.0.4103.106 Mobile Safari/537.36",
+			Software{"Chrome", "83.0.4103.106"}, Software{"Android", "9"}, DeviceMobile,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ua := parser.Parse(test.userAgent)
+
+			if ua.Browser != test.browser {
+				t.Errorf("expected browser %+v, got %+v", test.browser, ua.Browser)
+			}
+
+			if ua.Os != test.os {
+				t.Errorf("expected os %+v, got %+v", test.os, ua.Os)
+			}
+
+			if ua.Device.Type != test.device {
+				t.Errorf("expected device %s, got %s", test.device, ua.Device.Type)
+			}
+		})
+	}
+}
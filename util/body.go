@@ -0,0 +1,82 @@
+package util
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+)
+
+// CaptureBody reads up to limit bytes of a body so it can be inspected, the returned body replays those bytes
+// followed by the rest of the original. Complete is false when the body was larger than the limit, a limit of
+// zero captures the whole body
+func CaptureBody(body io.ReadCloser, limit int64) (captured []byte, replay io.ReadCloser, complete bool, err error) {
+	var reader io.Reader = body
+
+	if limit > 0 {
+		reader = io.LimitReader(body, limit+1)
+	}
+
+	captured, err = ioutil.ReadAll(reader)
+
+	if err != nil {
+		return nil, body, false, err
+	}
+
+	if limit <= 0 || int64(len(captured)) <= limit {
+		body.Close()
+
+		return captured, ioutil.NopCloser(bytes.NewReader(captured)), true, nil
+	}
+
+	replay = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(captured), body), body}
+
+	return captured, replay, false, nil
+}
+
+// CaptureReadCloser copies everything read from the body up to the limit, once the body has been read to the
+// end OnComplete is called with the copy. Nothing is reported when the body was over the limit or not fully read,
+// a limit of zero captures the whole body
+type CaptureReadCloser struct {
+	io.ReadCloser
+	Limit      int64
+	OnComplete func(captured []byte)
+
+	buffer    bytes.Buffer
+	truncated bool
+	done      bool
+}
+
+func NewCaptureReadCloser(body io.ReadCloser, limit int64, onComplete func(captured []byte)) *CaptureReadCloser {
+	return &CaptureReadCloser{
+		ReadCloser: body,
+		Limit:      limit,
+		OnComplete: onComplete,
+	}
+}
+
+func (c *CaptureReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+
+	if n > 0 && !c.truncated {
+		if c.Limit > 0 && int64(c.buffer.Len()+n) > c.Limit {
+			// Released straight away rather than holding on to a partial copy
+			c.truncated = true
+			c.buffer = bytes.Buffer{}
+		} else {
+			c.buffer.Write(p[:n])
+		}
+	}
+
+	if err == io.EOF && !c.done {
+		c.done = true
+
+		if !c.truncated {
+			c.OnComplete(c.buffer.Bytes())
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,82 @@
+package util
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCaptureBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		limit    int64
+		captured string
+		complete bool
+	}{
+		{"under the limit", "hello", 10, "hello", true},
+		{"at the limit", "hello", 5, "hello", true},
+		{"over the limit", "hello world", 5, "hello ", false},
+		{"empty", "", 5, "", true},
+		{"no limit", "hello world", 0, "hello world", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			captured, replay, complete, err := CaptureBody(ioutil.NopCloser(strings.NewReader(test.body)), test.limit)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(captured) != test.captured || complete != test.complete {
+				t.Errorf("expected %q (complete %v), got %q (complete %v)", test.captured, test.complete, captured, complete)
+			}
+
+			// The full body must still be available to forward
+			if replayed, _ := ioutil.ReadAll(replay); string(replayed) != test.body {
+				t.Errorf("expected the replayed body %q, got %q", test.body, replayed)
+			}
+		})
+	}
+}
+
+func TestCaptureReadCloser(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		limit    int64
+		reported bool
+	}{
+		{"under the limit", "hello", 10, true},
+		{"over the limit", "hello world", 5, false},
+		{"no limit", "hello world", 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := 0
+			var captured []byte
+
+			reader := NewCaptureReadCloser(ioutil.NopCloser(strings.NewReader(test.body)), test.limit, func(body []byte) {
+				calls++
+				captured = body
+			})
+
+			read, _ := ioutil.ReadAll(reader)
+			reader.Read(make([]byte, 1))
+
+			if string(read) != test.body {
+				t.Errorf("expected the whole body to be read, got %q", read)
+			}
+
+			if test.reported && (calls != 1 || string(captured) != test.body) {
+				t.Errorf("expected a single call with %q, got %d calls with %q", test.body, calls, captured)
+			}
+
+			if !test.reported && calls != 0 {
+				t.Errorf("expected no calls when over the limit, got %d", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,366 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrDecodedBodyTooLarge is returned when a body decodes to more than the allowed size, which protects the
+// proxy against decompression bombs
+var ErrDecodedBodyTooLarge = errors.New("decoded body is over the size limit")
+
+type UnsupportedEncodingError struct {
+	Encoding string
+}
+
+func (e UnsupportedEncodingError) Error() string {
+	return "unsupported Content-Encoding: " + e.Encoding
+}
+
+// Codec decodes and encodes one of the HTTP content codings, eg. gzip
+type Codec struct {
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+	NewWriter func(w io.Writer) (io.WriteCloser, error)
+}
+
+var (
+	codecsMutex sync.RWMutex
+	codecs      = map[string]Codec{
+		"gzip": {
+			NewReader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+			NewWriter: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		},
+		"deflate": {
+			NewReader: newDeflateReader,
+			NewWriter: func(w io.Writer) (io.WriteCloser, error) { return zlib.NewWriter(w), nil },
+		},
+		"br": {
+			NewReader: func(r io.Reader) (io.ReadCloser, error) { return ioutil.NopCloser(brotli.NewReader(r)), nil },
+			NewWriter: func(w io.Writer) (io.WriteCloser, error) { return brotli.NewWriter(w), nil },
+		},
+		"zstd": {
+			NewReader: func(r io.Reader) (io.ReadCloser, error) {
+				decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+
+				if err != nil {
+					return nil, err
+				}
+
+				return decoder.IOReadCloser(), nil
+			},
+			NewWriter: func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1)) },
+		},
+	}
+)
+
+func init() {
+	codecs["x-gzip"] = codecs["gzip"]
+}
+
+// RegisterCodec adds or replaces the codec used for a Content-Encoding
+func RegisterCodec(encoding string, codec Codec) {
+	codecsMutex.Lock()
+	defer codecsMutex.Unlock()
+
+	codecs[strings.ToLower(encoding)] = codec
+}
+
+func LookupCodec(encoding string) (Codec, bool) {
+	codecsMutex.RLock()
+	defer codecsMutex.RUnlock()
+
+	codec, ok := codecs[strings.ToLower(encoding)]
+
+	return codec, ok
+}
+
+// ParseContentEncoding lists the codings in the order they were applied, identity is left out
+func ParseContentEncoding(header string) []string {
+	var encodings []string
+
+	for _, encoding := range strings.Split(header, ",") {
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+
+		if encoding != "" && encoding != "identity" {
+			encodings = append(encodings, encoding)
+		}
+	}
+
+	return encodings
+}
+
+// DecodeBody undoes every coding in the Content-Encoding header (eg. "deflate, gzip"), last applied first.
+// Zero means there is no limit on the decoded size
+func DecodeBody(data []byte, contentEncoding string, limit int64) ([]byte, error) {
+	encodings := ParseContentEncoding(contentEncoding)
+
+	for i := len(encodings) - 1; i >= 0 && len(data) > 0; i-- {
+		codec, ok := LookupCodec(encodings[i])
+
+		if !ok {
+			return nil, UnsupportedEncodingError{Encoding: encodings[i]}
+		}
+
+		decoded, err := decodeWith(codec, data, limit)
+
+		if err != nil {
+			if err == ErrDecodedBodyTooLarge {
+				return nil, err
+			}
+
+			return nil, fmt.Errorf("could not decode %s body: %v", encodings[i], err)
+		}
+
+		data = decoded
+	}
+
+	return data, nil
+}
+
+// EncodeBody applies the codings in the order they are listed in the Content-Encoding header
+func EncodeBody(data []byte, contentEncoding string) ([]byte, error) {
+	for _, encoding := range ParseContentEncoding(contentEncoding) {
+		codec, ok := LookupCodec(encoding)
+
+		if !ok {
+			return nil, UnsupportedEncodingError{Encoding: encoding}
+		}
+
+		var buffer bytes.Buffer
+
+		writer, err := codec.NewWriter(&buffer)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+
+		data = buffer.Bytes()
+	}
+
+	return data, nil
+}
+
+func decodeWith(codec Codec, data []byte, limit int64) ([]byte, error) {
+	reader, err := codec.NewReader(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer reader.Close()
+
+	if limit <= 0 {
+		return ioutil.ReadAll(reader)
+	}
+
+	decoded, err := ioutil.ReadAll(io.LimitReader(reader, limit+1))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(decoded)) > limit {
+		return nil, ErrDecodedBodyTooLarge
+	}
+
+	return decoded, nil
+}
+
+// "deflate" should be zlib wrapped but some clients send a raw deflate stream, the zlib header tells them apart
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(2)
+
+	if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+
+	return flate.NewReader(buffered), nil
+}
+
+// NegotiateEncoding picks the offered coding (in order of preference) with the highest quality in the
+// Accept-Encoding header, an empty string means the body should be sent as it is
+func NegotiateEncoding(acceptEncoding string, offered []string) string {
+	accepted := parseAcceptEncoding(acceptEncoding)
+	best, bestQuality := "", 0.0
+
+	for _, encoding := range offered {
+		if quality := accepted.quality(encoding); quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+
+	return best
+}
+
+// AcceptsEncodings is true when the client accepts every one of the codings, eg. those of an upstream response
+func AcceptsEncodings(acceptEncoding string, encodings []string) bool {
+	accepted := parseAcceptEncoding(acceptEncoding)
+
+	for _, encoding := range encodings {
+		if accepted.quality(encoding) <= 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+type acceptedEncodings map[string]float64
+
+func parseAcceptEncoding(header string) acceptedEncodings {
+	accepted := acceptedEncodings{}
+
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		encoding := strings.ToLower(strings.TrimSpace(params[0]))
+
+		if encoding == "" {
+			continue
+		}
+
+		quality := 1.0
+
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+
+		accepted[encoding] = quality
+	}
+
+	return accepted
+}
+
+func (a acceptedEncodings) quality(encoding string) float64 {
+	encoding = strings.ToLower(encoding)
+
+	if encoding == "x-gzip" {
+		encoding = "gzip"
+	}
+
+	if quality, ok := a[encoding]; ok {
+		return quality
+	}
+
+	if encoding == "gzip" {
+		if quality, ok := a["x-gzip"]; ok {
+			return quality
+		}
+	}
+
+	if quality, ok := a["*"]; ok {
+		return quality
+	}
+
+	return 0
+}
+
+// NewDecodingReadCloser streams the decoded body, closing it closes the original body
+func NewDecodingReadCloser(body io.ReadCloser, contentEncoding string) (io.ReadCloser, error) {
+	encodings := ParseContentEncoding(contentEncoding)
+	var reader io.Reader = body
+	closers := []io.Closer{body}
+
+	for i := len(encodings) - 1; i >= 0; i-- {
+		codec, ok := LookupCodec(encodings[i])
+
+		if !ok {
+			return nil, UnsupportedEncodingError{Encoding: encodings[i]}
+		}
+
+		decoder, err := codec.NewReader(reader)
+
+		if err != nil {
+			return nil, fmt.Errorf("could not decode %s body: %v", encodings[i], err)
+		}
+
+		reader = decoder
+		closers = append(closers, decoder)
+	}
+
+	return &stackedReadCloser{Reader: reader, closers: closers}, nil
+}
+
+type stackedReadCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (s *stackedReadCloser) Close() error {
+	var err error
+
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		if closeErr := s.closers[i].Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// NewEncodingReadCloser compresses the body as it is read, the encoding happens in the background so the
+// original body is only read as fast as the client reads the encoded one
+func NewEncodingReadCloser(body io.ReadCloser, encoding string) (io.ReadCloser, error) {
+	codec, ok := LookupCodec(encoding)
+
+	if !ok {
+		return nil, UnsupportedEncodingError{Encoding: encoding}
+	}
+
+	reader, writer := io.Pipe()
+	encoder, err := codec.NewWriter(writer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		_, err := io.Copy(encoder, body)
+
+		if closeErr := encoder.Close(); err == nil {
+			err = closeErr
+		}
+
+		writer.CloseWithError(err)
+	}()
+
+	return &encodingReadCloser{PipeReader: reader, body: body}, nil
+}
+
+type encodingReadCloser struct {
+	*io.PipeReader
+	body io.ReadCloser
+}
+
+func (e *encodingReadCloser) Close() error {
+	// Stops the background copy if the client went away before reading everything
+	e.PipeReader.Close()
+
+	return e.body.Close()
+}
@@ -0,0 +1,132 @@
+package util
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestDecodeBody(t *testing.T) {
+	body := `{"query":{"match_all":{}}}`
+
+	for _, encoding := range []string{"", "identity", "gzip", "x-gzip", "deflate", "br", "zstd", "deflate, gzip", "zstd,br"} {
+		t.Run(encoding, func(t *testing.T) {
+			encoded, err := EncodeBody([]byte(body), encoding)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := DecodeBody(encoded, encoding, 1024)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(decoded) != body {
+				t.Errorf("expected %q, got %q", body, decoded)
+			}
+		})
+	}
+}
+
+func TestDecodeBodyRawDeflate(t *testing.T) {
+	var buffer bytes.Buffer
+
+	writer, _ := flate.NewWriter(&buffer, flate.DefaultCompression)
+	writer.Write([]byte("raw deflate"))
+	writer.Close()
+
+	decoded, err := DecodeBody(buffer.Bytes(), "deflate", 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(decoded) != "raw deflate" {
+		t.Errorf("expected %q, got %q", "raw deflate", decoded)
+	}
+}
+
+func TestDecodeBodyLimit(t *testing.T) {
+	bomb, _ := EncodeBody([]byte(strings.Repeat("a", 1024*1024)), "gzip")
+
+	if _, err := DecodeBody(bomb, "gzip", 1024); err != ErrDecodedBodyTooLarge {
+		t.Errorf("expected %v, got %v", ErrDecodedBodyTooLarge, err)
+	}
+
+	if _, err := DecodeBody(bomb, "gzip", 0); err != nil {
+		t.Errorf("expected no limit, got %v", err)
+	}
+}
+
+func TestDecodeBodyErrors(t *testing.T) {
+	if _, err := DecodeBody([]byte("data"), "compress", 0); err != (UnsupportedEncodingError{Encoding: "compress"}) {
+		t.Errorf("expected an unsupported encoding error, got %v", err)
+	}
+
+	if _, err := DecodeBody([]byte("not gzip"), "gzip", 0); err == nil {
+		t.Error("expected an error for an invalid gzip body")
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	offered := []string{"br", "gzip", "zstd"}
+
+	tests := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"gzip;q=1.0, br;q=0.5", "gzip"},
+		{"zstd, br;q=0", "zstd"},
+		{"*", "br"},
+		{"*;q=0.5, gzip", "gzip"},
+		{"x-gzip", "gzip"},
+		{"identity", ""},
+		{"deflate", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.acceptEncoding, func(t *testing.T) {
+			if encoding := NegotiateEncoding(test.acceptEncoding, offered); encoding != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, encoding)
+			}
+		})
+	}
+}
+
+func TestStreamingCodecs(t *testing.T) {
+	body := strings.Repeat(`{"hits":[]}`, 100)
+
+	for _, encoding := range []string{"gzip", "br", "zstd", "deflate"} {
+		t.Run(encoding, func(t *testing.T) {
+			encoded, err := NewEncodingReadCloser(ioutil.NopCloser(strings.NewReader(body)), encoding)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := NewDecodingReadCloser(encoded, encoding)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := ioutil.ReadAll(decoded)
+			decoded.Close()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(result) != body {
+				t.Errorf("expected the body to survive encoding, got %q", result)
+			}
+		})
+	}
+}
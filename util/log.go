@@ -3,6 +3,7 @@ package util
 import (
 	"fmt"
 	"github.com/apex/log"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,3 +14,34 @@ func LogData(fields *log.Fields) {
 func LogMsg(message string) string {
 	return fmt.Sprintf("%s Logger: %s", time.Now(), message)
 }
+
+// LevelHandler filters entries by a level that can be changed while other goroutines are logging, setting the
+// apex logger's own Level on a config reload would race with every log call
+type LevelHandler struct {
+	Handler log.Handler
+
+	level int32
+}
+
+func NewLevelHandler(handler log.Handler, level log.Level) *LevelHandler {
+	h := &LevelHandler{Handler: handler}
+	h.SetLevel(level)
+
+	return h
+}
+
+func (h *LevelHandler) SetLevel(level log.Level) {
+	atomic.StoreInt32(&h.level, int32(level))
+}
+
+func (h *LevelHandler) Level() log.Level {
+	return log.Level(atomic.LoadInt32(&h.level))
+}
+
+func (h *LevelHandler) HandleLog(entry *log.Entry) error {
+	if entry.Level < h.Level() {
+		return nil
+	}
+
+	return h.Handler.HandleLog(entry)
+}
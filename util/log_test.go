@@ -0,0 +1,23 @@
+package util
+
+import (
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+	"testing"
+)
+
+func TestLevelHandler(t *testing.T) {
+	memoryHandler := memory.New()
+	handler := NewLevelHandler(memoryHandler, log.InfoLevel)
+	logger := &log.Logger{Handler: handler, Level: log.DebugLevel}
+
+	logger.Debug("hidden")
+	logger.Info("shown")
+
+	handler.SetLevel(log.DebugLevel)
+	logger.Debug("shown after reload")
+
+	if len(memoryHandler.Entries) != 2 || memoryHandler.Entries[1].Message != "shown after reload" {
+		t.Errorf("expected the entries at or above the level, got %+v", memoryHandler.Entries)
+	}
+}
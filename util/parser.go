@@ -2,102 +2,58 @@ package util
 
 import (
 	"bytes"
-	"compress/gzip"
 	"encoding/json"
 	"fmt"
-	"github.com/andybalholm/brotli"
-	"github.com/apex/log"
 	"io/ioutil"
 	"net/http"
 )
 
-func DecodeRequestBodyToBytes(request *http.Request) []byte {
+func DecodeRequestBodyToBytes(request *http.Request) ([]byte, error) {
 	// Read body to buffer
 	body, err := ioutil.ReadAll(request.Body)
 	if err != nil {
-		log.Error(fmt.Sprintf("Error reading body: %v", err))
-		panic(err)
+		return nil, fmt.Errorf("error reading request body: %v", err)
 	}
 
 	// Because go lang is a pain in the ass if you read the body then any subsequent calls
 	// are unable to read the body again....
 	request.Body = ioutil.NopCloser(bytes.NewBuffer(body))
 
-	return body
+	return body, nil
 }
 
-func DecodeRequestBodyToString(request *http.Request) string {
-	return string(DecodeRequestBodyToBytes(request)[:])
+func DecodeRequestBodyToString(request *http.Request) (string, error) {
+	body, err := DecodeRequestBodyToBytes(request)
+
+	return string(body), err
 }
 
-func NewRequestBodyJsonDecoder(request *http.Request) *json.Decoder {
-	return json.NewDecoder(ioutil.NopCloser(bytes.NewBuffer(DecodeRequestBodyToBytes(request))))
+func NewRequestBodyJsonDecoder(request *http.Request) (*json.Decoder, error) {
+	body, err := DecodeRequestBodyToBytes(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.NewDecoder(bytes.NewReader(body)), nil
 }
 
-func DecodeResponseBodyToBytes(response *http.Response) []byte {
+func DecodeResponseBodyToBytes(response *http.Response) ([]byte, error) {
 
 	// Read body to buffer
 	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		log.Error(fmt.Sprintf("Error reading body: %v", err))
-		panic(err)
+		return nil, fmt.Errorf("error reading response body: %v", err)
 	}
 
 	// Because go lang is a pain in the ass if you read the body then any subsequent calls
 	// are unable to read the body again....
 	response.Body = ioutil.NopCloser(bytes.NewBuffer(body))
 
-	return body
-}
-
-func DecodeResponseBodyToString(response *http.Response) string {
-	return string(DecodeResponseBodyToBytes(response)[:])
+	return body, nil
 }
 
-func DecodeResponseBytes(data []byte, contentEncoding string) string {
-	if len(data) == 0 {
-		return ""
-	}
-
-	if contentEncoding == "" {
-		return string(data)
-	}
-
-	if contentEncoding == "br" {
-		// Decode brotli compression
-		br := bytes.NewReader(data)
-
-		brotliReader := brotli.NewReader(br)
+func DecodeResponseBodyToString(response *http.Response) (string, error) {
+	body, err := DecodeResponseBodyToBytes(response)
 
-		brBytes, err := ioutil.ReadAll(brotliReader)
-
-		if err != nil {
-			log.Error(err.Error())
-			return ""
-		}
-
-		return string(brBytes)
-	} else if contentEncoding == "gzip" {
-		gzipReader, err := gzip.NewReader(bytes.NewReader(data))
-
-		if err != nil {
-			log.Errorf("Could not create a new reader for gzip: ", err)
-			return ""
-		}
-
-		defer gzipReader.Close()
-
-		decompressed, err := ioutil.ReadAll(gzipReader)
-
-		if err != nil {
-			log.Errorf("Could not decode bytes to gzip: ", err)
-			return ""
-		}
-
-		return string(decompressed)
-	}
-
-	log.Error("Unsupported Content-Encoding: " + contentEncoding)
-
-	return ""
-}
\ No newline at end of file
+	return string(body), err
+}
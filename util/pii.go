@@ -0,0 +1,84 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+)
+
+var emailRegex = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+
+// Dots are not allowed as separators otherwise coordinates and prices would be scrubbed, the prefix prevents
+// matching the tail end of ids such as UUIDs
+var phoneRegex = regexp.MustCompile(`(^|[^\w-])\+?\d[\d\s()-]{8,}\d\b`)
+
+var jsonStringRegex = regexp.MustCompile(`"(?:[^"\\]|\\.)*"`)
+
+func ScrubPii(value string) string {
+	value = emailRegex.ReplaceAllString(value, "[email]")
+	value = phoneRegex.ReplaceAllString(value, "${1}[phone]")
+
+	return value
+}
+
+// Only scrubs inside of string literals so numeric values in the JSON are left alone
+func ScrubPiiJson(value string) string {
+	return jsonStringRegex.ReplaceAllStringFunc(value, ScrubPii)
+}
+
+func ScrubPiiUrlValues(encoded string) string {
+	values, err := url.ParseQuery(encoded)
+
+	if err != nil {
+		return ScrubPii(encoded)
+	}
+
+	for key, list := range values {
+		for i := range list {
+			list[i] = ScrubPii(list[i])
+		}
+
+		values[key] = list
+	}
+
+	return values.Encode()
+}
+
+func ScrubPiiUrl(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
+
+	if err != nil {
+		return ScrubPii(rawUrl)
+	}
+
+	if parsed.RawQuery != "" {
+		parsed.RawQuery = ScrubPiiUrlValues(parsed.RawQuery)
+	}
+
+	return parsed.String()
+}
+
+// Zeroes the host portion of the address, /24 for IPv4 and /48 for IPv6
+func TruncateIp(ip string) string {
+	parsed := net.ParseIP(ip)
+
+	if parsed == nil {
+		return ""
+	}
+
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
+func HashIp(ip string, key string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(ip))
+
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
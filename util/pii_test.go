@@ -0,0 +1,47 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestScrubPii(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{"email", "contact john.smith@example.co.uk please", "contact [email] please"},
+		{"phone", "call 07700 900123", "call [phone]"},
+		{"international phone", "+44 (0)1872 555123", "[phone]"},
+		{"coordinates are kept", "50.44334215,-4.95602575", "50.44334215,-4.95602575"},
+		{"uuids are kept", "550e8400-e29b-41d4-a716-446655440000", "550e8400-e29b-41d4-a716-446655440000"},
+		{"plain search", "sea view cottage", "sea view cottage"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if scrubbed := ScrubPii(test.value); scrubbed != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, scrubbed)
+			}
+		})
+	}
+}
+
+func TestScrubPiiJson(t *testing.T) {
+	query := `{"multi_match":{"query":"jane@example.com"},"range":{"pricing.visual.nightlyLow":{"gte":123456789012}}}`
+	expected := `{"multi_match":{"query":"[email]"},"range":{"pricing.visual.nightlyLow":{"gte":123456789012}}}`
+
+	if scrubbed := ScrubPiiJson(query); scrubbed != expected {
+		t.Errorf("expected %s, got %s", expected, scrubbed)
+	}
+}
+
+func TestTruncateIp(t *testing.T) {
+	if ip := TruncateIp("203.0.113.57"); ip != "203.0.113.0" {
+		t.Errorf("unexpected IPv4 truncation: %s", ip)
+	}
+
+	if ip := TruncateIp("2001:db8:cafe:1234::17"); ip != "2001:db8:cafe::" {
+		t.Errorf("unexpected IPv6 truncation: %s", ip)
+	}
+}